@@ -0,0 +1,113 @@
+package tktext
+
+import "strings"
+
+// Return the ranges covered by the rectangle with the given corners, one per
+// line, along with the left and right columns of the rectangle
+func (t *TkText) blockRanges(pos1, pos2 Position) ([]Range, int, int) {
+	if pos1.Line > pos2.Line {
+		pos1, pos2 = pos2, pos1
+	}
+	col1 := t.column(pos1)
+	col2 := t.column(pos2)
+	if col1 > col2 {
+		col1, col2 = col2, col1
+	}
+
+	ranges := make([]Range, 0, pos2.Line-pos1.Line+1)
+	t.lines.each(pos1.Line, func(n int, s string) bool {
+		widths := t.tabWidths(n, s)
+		start, _ := charAt(s, col1, widths)
+		end, _ := charAt(s, col2, widths)
+		ranges = append(ranges, Range{Position{n, start}, Position{n, end}})
+		return n < pos2.Line
+	})
+	return ranges, col1, col2
+}
+
+// BlockGet returns the text in the rectangle with the given corners as a
+// slice of strings, one per line. The left and right edges of the rectangle
+// are the display columns of the two indices, so tabs are taken into account.
+// A character is within the rectangle if the column at which it starts is.
+func (t *TkText) BlockGet(index1, index2 string) []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	ranges, _, _ := t.blockRanges(t.index(index1), t.index(index2))
+	lines := make([]string, len(ranges))
+	for i, r := range ranges {
+		lines[i] = t.get(r.Start, r.End)
+	}
+	return lines
+}
+
+// BlockDelete deletes the text in the rectangle with the given corners. The
+// deletion forms a single undoable change.
+func (t *TkText) BlockDelete(index1, index2 string) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	ranges, _, _ := t.blockRanges(t.index(index1), t.index(index2))
+	t.separate()
+	t.blockDelete(ranges)
+	t.separate()
+}
+
+func (t *TkText) blockDelete(ranges []Range) {
+	for i := len(ranges) - 1; i >= 0; i-- {
+		if ranges[i].Start != ranges[i].End {
+			t.del(ranges[i].Start, ranges[i].End, true)
+		}
+	}
+}
+
+// Insert lines at the given column of successive lines starting at the given
+// line, padding short lines with spaces and adding lines to the end of the
+// buffer as necessary
+func (t *TkText) blockInsert(lineNum, col int, lines []string) {
+	if extra := lineNum + len(lines) - 1 - t.lines.Len(); extra > 0 {
+		t.insert(t.end(), strings.Repeat("\n", extra), true)
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i] == "" {
+			continue
+		}
+		s := t.getLine(lineNum + i)
+		char, c := charAt(s, col, t.tabWidths(lineNum+i, s))
+		pad := ""
+		if c < col {
+			pad = strings.Repeat(" ", col-c)
+		}
+		t.insert(Position{lineNum + i, char}, pad+lines[i], true)
+	}
+}
+
+// BlockInsert inserts the given lines into successive lines of the buffer,
+// each at the display column of the given index, starting at the line of the
+// index. Lines shorter than the column are padded with spaces, and lines are
+// added to the end of the buffer if necessary. The insertion forms a single
+// undoable change.
+func (t *TkText) BlockInsert(index string, lines []string) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	pos := t.index(index)
+	col := t.column(pos)
+	t.separate()
+	t.blockInsert(pos.Line, col, lines)
+	t.separate()
+}
+
+// BlockReplace replaces the text in the rectangle with the given corners with
+// the given lines, as if by BlockDelete followed by BlockInsert at the
+// rectangle's top left corner. The replacement forms a single undoable change.
+func (t *TkText) BlockReplace(index1, index2 string, lines []string) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	ranges, col, _ := t.blockRanges(t.index(index1), t.index(index2))
+	t.separate()
+	t.blockDelete(ranges)
+	t.blockInsert(ranges[0].Start.Line, col, lines)
+	t.separate()
+}
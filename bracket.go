@@ -0,0 +1,208 @@
+package tktext
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token is a span of a line of text, identified by its kind. Start and End are
+// byte offsets from the start of the line.
+type Token struct {
+	Start, End int
+	Kind       string
+}
+
+// A Tokenizer splits lines of text into tokens. Since tokens such as comments
+// may span several lines, a tokenizer is given the state at the start of a
+// line and returns the state at its end. The state at the start of the buffer
+// is nil. So that only the lines whose starting states have changed are
+// relexed after an edit, states are compared with reflect.DeepEqual, and a
+// tokenizer must not modify the states it is given.
+type Tokenizer interface {
+	Tokenize(line string, state interface{}) (tokens []Token, end interface{})
+}
+
+var defaultBracketPairs = parsePairs([]string{"()", "[]", "{}"})
+
+// Parse a list of two-character strings into pairs of runes. A pair of the
+// same rune twice, such as quotes, is rejected, since an opening bracket
+// could not be told from a closing one.
+func parsePairs(pairs []string) [][2]rune {
+	parsed := make([][2]rune, len(pairs))
+	for i, pair := range pairs {
+		r := []rune(pair)
+		if len(r) != 2 || r[0] == r[1] {
+			panic(errors.New("Bad bracket pair: " + pair))
+		}
+		parsed[i] = [2]rune{r[0], r[1]}
+	}
+	return parsed
+}
+
+// Report whether brackets in tokens of the given kind are ignored when
+// matching brackets
+func skipKind(kind string) bool {
+	for _, k := range []string{"string", "comment"} {
+		if kind == k || strings.HasPrefix(kind, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTokenizer sets the tokenizer used to lex the buffer. The tokens of each
+// line are cached, and when lines are edited, lines are lexed again from the
+// first edited line until the tokenizer's state at the start of a line is the
+// same as before the edit. Tokens are published as style runs by
+// GetScreenStyles, and brackets in tokens of the kinds "string" and "comment",
+// or of kinds beginning with "string." and "comment.", are skipped when
+// matching brackets. No tokenizer is set by default.
+func (t *TkText) SetTokenizer(tok Tokenizer) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.tokenizer = tok
+	t.damageAll()
+	if tok != nil {
+		t.relex(1, t.lines.Len())
+	}
+}
+
+// SetBracketPairs sets the pairs of brackets that are matched by MatchBracket
+// and the "matchbracket" index modifier. Each pair is a string of an opening
+// and a closing bracket. Calling SetBracketPairs with no pairs restores the
+// default pairs, "()", "[]", and "{}". Panics if a pair is not exactly two
+// different characters.
+func (t *TkText) SetBracketPairs(pairs ...string) {
+	parsed := defaultBracketPairs
+	if len(pairs) > 0 {
+		parsed = parsePairs(pairs)
+	}
+	t.mutex.Lock()
+	defer t.unlock()
+	t.bracketPairs = parsed
+}
+
+// MatchBracket returns the position of the bracket that matches the bracket
+// at the given index, taking nesting into account. Returns false if there is
+// no bracket at the index or it has no match. If a tokenizer is set, brackets
+// in strings and comments are skipped, unless the bracket at the index is in
+// one, in which case only brackets in the same token are considered.
+func (t *TkText) MatchBracket(index string) (Position, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.matchBracket(t.index(index))
+}
+
+// Return the index of the token containing byte i, or -1 if there is none
+func tokenAt(tokens []Token, i int) int {
+	for k, tok := range tokens {
+		if tok.Start <= i && i < tok.End {
+			return k
+		}
+	}
+	return -1
+}
+
+func (t *TkText) matchBracket(pos Position) (Position, bool) {
+	line := t.getLine(pos.Line)
+	if pos.Char >= len(line) {
+		return pos, false
+	}
+	ch, _ := utf8.DecodeRuneInString(line[pos.Char:])
+	var pair [2]rune
+	forward, found := false, false
+	for _, p := range t.bracketPairs {
+		if ch == p[0] || ch == p[1] {
+			pair, forward, found = p, ch == p[0], true
+			break
+		}
+	}
+	if !found {
+		return pos, false
+	}
+
+	// Find the token containing the bracket, if any
+	tokens := t.lineTokens(pos.Line)
+	within := -1
+	if k := tokenAt(tokens, pos.Char); k >= 0 && skipKind(tokens[k].Kind) {
+		within = k
+	}
+
+	depth := 0
+	for n := pos.Line; ; {
+		// Scan the line from the bracket, or from the appropriate end
+		lo, hi := 0, len(line)
+		if n == pos.Line {
+			if forward {
+				lo = pos.Char
+			} else {
+				hi = pos.Char + utf8.RuneLen(ch)
+			}
+		}
+		if within >= 0 {
+			lo, hi = maxInt(lo, tokens[within].Start), minInt(hi, tokens[within].End)
+		}
+		for _, r := range scanOrder(line, lo, hi, forward) {
+			c, _ := utf8.DecodeRuneInString(line[r:])
+			if c != pair[0] && c != pair[1] {
+				continue
+			}
+			if within < 0 {
+				if k := tokenAt(tokens, r); k >= 0 && skipKind(tokens[k].Kind) {
+					continue
+				}
+			}
+			if c == ch {
+				depth++
+			} else if depth--; depth == 0 {
+				return Position{n, r}, true
+			}
+		}
+		if within >= 0 {
+			break
+		}
+
+		// Move to the next line in the direction of the scan
+		if forward {
+			n++
+		} else {
+			n--
+		}
+		if n < 1 || n > t.lines.Len() {
+			break
+		}
+		line = t.getLine(n)
+		tokens = t.lineTokens(n)
+	}
+	return pos, false
+}
+
+// Return the byte offsets of the characters in s[lo:hi], in reverse order if
+// forward is false
+func scanOrder(s string, lo, hi int, forward bool) []int {
+	var offsets []int
+	for i := range s[lo:hi] {
+		offsets = append(offsets, lo+i)
+	}
+	if !forward {
+		for i, j := 0, len(offsets)-1; i < j; i, j = i+1, j-1 {
+			offsets[i], offsets[j] = offsets[j], offsets[i]
+		}
+	}
+	return offsets
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,254 @@
+package tktext
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A cursor is an insertion point with an optional selection, identified by
+// the names of the marks at either end.
+type cursor struct {
+	anchor, insert string
+}
+
+// Return the primary cursor followed by any additional cursors whose insert
+// marks are still set
+func (t *TkText) cursorList() []cursor {
+	var cursors []cursor
+	if t.marks[insertMark] != nil {
+		cursors = append(cursors, cursor{anchorMark, insertMark})
+	}
+	for _, c := range t.cursors {
+		if t.marks[c.insert] != nil {
+			cursors = append(cursors, c)
+		}
+	}
+	return cursors
+}
+
+// Return the cursors sorted by insert position
+func (t *TkText) sortedCursors(reverse bool) []cursor {
+	byName := make(map[string]cursor)
+	var marks []*mark
+	for _, c := range t.cursorList() {
+		byName[c.insert] = c
+		marks = append(marks, t.marks[c.insert])
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(markSort(marks)))
+	} else {
+		sort.Sort(markSort(marks))
+	}
+	cursors := make([]cursor, len(marks))
+	for i, m := range marks {
+		cursors[i] = byName[m.name]
+	}
+	return cursors
+}
+
+// Remove additional cursors whose insert positions or selections overlap
+// those of other cursors, extending the remaining cursors to cover them
+func (t *TkText) mergeCursors() {
+	cursors := t.cursorList()
+	spans := make([]Range, len(cursors))
+	for i, c := range cursors {
+		spans[i].Start = t.marks[c.insert].Position
+		spans[i].End = spans[i].Start
+		if anchor := t.marks[c.anchor]; anchor != nil {
+			if comparePos(anchor.Position, spans[i].Start) < 0 {
+				spans[i].Start = anchor.Position
+			} else {
+				spans[i].End = anchor.Position
+			}
+		}
+	}
+
+	kept := make([]bool, len(cursors))
+	for i := range cursors {
+		kept[i] = true
+		for j := 0; j < i; j++ {
+			if !kept[j] || !spansOverlap(spans[i], spans[j]) {
+				continue
+			}
+
+			// Fold cursor i into cursor j, preserving j's direction
+			kept[i] = false
+			a, b := spans[j], spans[i]
+			if comparePos(b.Start, a.Start) < 0 {
+				a.Start = b.Start
+			}
+			if comparePos(b.End, a.End) > 0 {
+				a.End = b.End
+			}
+			spans[j] = a
+			first, last := a.Start, a.End
+			if anchor := t.marks[cursors[j].anchor]; anchor != nil &&
+				comparePos(anchor.Position, t.marks[cursors[j].insert].Position) > 0 {
+				first, last = last, first
+			}
+			if a.Start != a.End {
+				t.setMark(cursors[j].anchor, first)
+			}
+			t.setMark(cursors[j].insert, last)
+			delete(t.marks, cursors[i].anchor)
+			delete(t.marks, cursors[i].insert)
+			break
+		}
+	}
+
+	extra := t.cursors[:0]
+	for _, c := range t.cursors {
+		if t.marks[c.insert] != nil {
+			extra = append(extra, c)
+		}
+	}
+	t.cursors = extra
+}
+
+// Report whether two cursor spans overlap or share an insertion point
+func spansOverlap(a, b Range) bool {
+	if a.Start == a.End && b.Start == b.End {
+		return a.Start == b.Start
+	}
+	return comparePos(a.Start, b.End) < 0 && comparePos(b.Start, a.End) < 0
+}
+
+// Set a mark to a position, creating it if necessary
+func (t *TkText) setMark(name string, pos Position) {
+	if m := t.marks[name]; m != nil {
+		m.Position = pos
+	} else {
+		t.marks[name] = &mark{pos, Right, name}
+	}
+}
+
+// CursorAdd adds a cursor with its insertion point at the insert index and a
+// selection extending to the anchor index. If the indices are equal, the
+// cursor has no selection. The first cursor uses the "anchor" and "insert"
+// marks of the primary selection; additional cursors use marks named
+// "anchor.N" and "insert.N". Cursors that overlap existing cursors are merged
+// into them.
+func (t *TkText) CursorAdd(anchor, insert string) {
+	t.mutex.Lock()
+	defer t.unlock()
+	anchorPos, insertPos := t.index(anchor), t.index(insert)
+	c := cursor{anchorMark, insertMark}
+	if t.marks[insertMark] != nil {
+		t.cursorID++
+		c = cursor{fmt.Sprintf("%s.%d", anchorMark, t.cursorID),
+			fmt.Sprintf("%s.%d", insertMark, t.cursorID)}
+		t.cursors = append(t.cursors, c)
+	}
+	t.setMark(c.anchor, anchorPos)
+	t.setMark(c.insert, insertPos)
+	t.mergeCursors()
+}
+
+// CursorClear removes all cursors except the primary one.
+func (t *TkText) CursorClear() {
+	t.mutex.Lock()
+	defer t.unlock()
+	for _, c := range t.cursors {
+		delete(t.marks, c.anchor)
+		delete(t.marks, c.insert)
+	}
+	t.cursors = nil
+}
+
+// Cursors returns the insertion points of all cursors in order.
+func (t *TkText) Cursors() []Position {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	cursors := t.sortedCursors(false)
+	positions := make([]Position, len(cursors))
+	for i, c := range cursors {
+		positions[i] = t.marks[c.insert].Position
+	}
+	return positions
+}
+
+// Collapse the selection of a cursor to its insertion point
+func (t *TkText) collapseCursor(c cursor) {
+	if m := t.marks[c.anchor]; m != nil {
+		m.Position = t.marks[c.insert].Position
+	}
+}
+
+// CursorInsert inserts text at every cursor, replacing the selected text of
+// cursors that have a selection. All of the changes form a single undoable
+// change.
+func (t *TkText) CursorInsert(s string) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	t.separate()
+	for _, c := range t.sortedCursors(true) {
+		if r, ok := t.cursorRange(c); ok {
+			t.del(r.Start, r.End, true)
+		}
+		if s != "" {
+			t.insert(t.marks[c.insert].Position, s, true)
+		}
+		t.collapseCursor(c)
+	}
+	t.separate()
+	t.selUnit = SelectChar
+	t.mergeCursors()
+}
+
+// CursorDelete deletes the selected text of every cursor. For cursors without
+// a selection, the text between the insertion point and the index obtained by
+// appending the given modifier to the cursor's insert mark is deleted; for
+// example, "-1c" deletes the preceding character. All of the changes form a
+// single undoable change.
+func (t *TkText) CursorDelete(modifier string) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	t.separate()
+	for _, c := range t.sortedCursors(true) {
+		r, ok := t.cursorRange(c)
+		if !ok {
+			r.Start = t.marks[c.insert].Position
+			r.End = t.index(c.insert + modifier)
+			if comparePos(r.End, r.Start) < 0 {
+				r.Start, r.End = r.End, r.Start
+			}
+		}
+		if r.Start != r.End {
+			t.del(r.Start, r.End, true)
+		}
+		t.collapseCursor(c)
+	}
+	t.separate()
+	t.selUnit = SelectChar
+	t.mergeCursors()
+}
+
+// CursorMove moves the insertion point of every cursor to the index obtained
+// by appending the given modifier to the cursor's insert mark; for example,
+// "+1l" moves each cursor down one line. If extend is true, each cursor's
+// selection is extended to the new insertion point; otherwise, selections are
+// cleared.
+func (t *TkText) CursorMove(modifier string, extend bool) {
+	t.mutex.Lock()
+	defer t.unlock()
+	cursors := t.cursorList()
+	positions := make([]Position, len(cursors))
+	for i, c := range cursors {
+		positions[i] = t.index(c.insert + modifier)
+	}
+	for i, c := range cursors {
+		if extend && t.marks[c.anchor] == nil {
+			t.setMark(c.anchor, t.marks[c.insert].Position)
+		}
+		t.setMark(c.insert, positions[i])
+		if !extend {
+			t.collapseCursor(c)
+		}
+	}
+	if !extend {
+		t.selUnit = SelectChar
+	}
+	t.mergeCursors()
+}
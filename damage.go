@@ -0,0 +1,160 @@
+package tktext
+
+import "sort"
+
+// Line number used as the last line of a damaged span that extends to the end
+// of the display
+const endOfDisplay = int(^uint(0) >> 1)
+
+// Damage describes which display lines of the screen have changed since the
+// last call to TkText.Damage.
+type Damage struct {
+	// Full is true if the whole screen must be redrawn, because the view was
+	// scrolled or resized, or a display setting was changed.
+	Full bool
+
+	// Rows lists the changed display lines in increasing order, counting
+	// from zero at the top of the screen, like the strings returned by
+	// GetScreenLines. Display lines below the end of the buffer, which are
+	// damaged when text is deleted, continue the count with the default
+	// line height. Rows is empty if Full is true.
+	Rows []int
+}
+
+// The parameters of the view that invalidate the whole screen when changed
+type viewState struct {
+	width, height    int
+	xScroll, yScroll int
+	yOffset          int
+	wrapMode         WrapMode
+	gutter           GutterMode
+	insert           int // Line of the insert mark, if numbers are relative
+}
+
+// The lines damaged since damage was last reported
+type damageState struct {
+	all   bool
+	spans []lineSpan // Sorted and merged
+	view  viewState  // View when damage was last reported
+}
+
+// Damage returns the display lines that have changed since the last call, and
+// resets the record of changes. Rows are damaged by edits to the buffer,
+// including edits to other lines that shift them up or down, rewrap them, or
+// change their highlighting or elastic tabs, and by folds opening and closing.
+// Changes to marks, including the selection and cursors, are not tracked,
+// except that the gutter is damaged by movement of the insert mark if it shows
+// relative numbers. The first call always reports a full redraw.
+func (t *TkText) Damage() Damage {
+	t.mutex.Lock()
+	defer t.unlock()
+	view := t.viewState()
+	d := Damage{Full: t.damage.all || view != t.damage.view}
+	if !d.Full && len(t.damage.spans) > 0 {
+		screen := t.screenLines()
+		for i, sl := range screen {
+			if t.lineDamaged(sl.line) {
+				d.Rows = append(d.Rows, i)
+			}
+		}
+
+		// Display lines below the end of the buffer have the default height
+		if t.damage.spans[len(t.damage.spans)-1].last == endOfDisplay {
+			y, i := -t.yOffset, len(screen)
+			if i > 0 {
+				y = screen[i-1].bottom
+			}
+			h, _ := t.uniformRowHeight()
+			for ; y < t.height; y, i = y+maxInt(h, 1), i+1 {
+				d.Rows = append(d.Rows, i)
+			}
+		}
+	}
+	t.damage = damageState{view: view}
+	return d
+}
+
+func (t *TkText) viewState() viewState {
+	v := viewState{t.width, t.height, t.xScroll, t.yScroll, t.yOffset,
+		t.wrapMode, t.gutter, 0}
+	if m := t.marks[insertMark]; m != nil && (t.gutter == RelativeNumbers ||
+		t.gutter == HybridNumbers) {
+		v.insert = m.Line
+	}
+	return v
+}
+
+// Report whether line n has been damaged
+func (t *TkText) lineDamaged(n int) bool {
+	spans := t.damage.spans
+	i := sort.Search(len(spans), func(i int) bool {
+		return spans[i].last >= n
+	})
+	return i < len(spans) && spans[i].first <= n
+}
+
+// Mark the whole screen as damaged
+func (t *TkText) damageAll() {
+	t.damage.all = true
+	t.damage.spans = nil
+}
+
+// Mark lines first through last as damaged
+func (t *TkText) damageLines(first, last int) {
+	if t.damage.all {
+		return
+	}
+	span := lineSpan{first, last}
+	var spans []lineSpan
+	placed := false
+	for _, s := range t.damage.spans {
+		switch {
+		case placed || s.last < span.first-1:
+			spans = append(spans, s)
+		case span.last < s.first-1:
+			spans = append(spans, span, s)
+			placed = true
+		default: // Overlapping or adjacent
+			span.first = minInt(span.first, s.first)
+			span.last = maxInt(span.last, s.last)
+		}
+	}
+	if !placed {
+		spans = append(spans, span)
+	}
+	t.damage.spans = spans
+}
+
+// Mark line n and every display line after it as damaged
+func (t *TkText) damageTail(n int) {
+	t.damageLines(n, endOfDisplay)
+}
+
+// Record the lines damaged by an edit that replaced lines first through
+// oldLast, which took up the given number of display rows, with lines first
+// through newLast
+func (t *TkText) damageEdit(first, oldLast, newLast, rows int) {
+	s := t.getLine(first)
+	if oldLast != newLast || t.displayRows(first, s, len(s)) != rows {
+		t.damageTail(first)
+	} else {
+		t.damageLines(first, newLast)
+	}
+}
+
+// Record the lines damaged by a change to the spans of hidden lines
+func (t *TkText) damageElided(old, spans []lineSpan) {
+	for i := 0; i < len(old) || i < len(spans); i++ {
+		switch {
+		case i >= len(old):
+			t.damageTail(spans[i].first - 1)
+		case i >= len(spans):
+			t.damageTail(old[i].first - 1)
+		case old[i] != spans[i]:
+			t.damageTail(minInt(old[i].first, spans[i].first) - 1)
+		default:
+			continue
+		}
+		return
+	}
+}
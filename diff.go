@@ -0,0 +1,552 @@
+package tktext
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DiffAlgorithm determines how the lines of two texts are matched.
+type DiffAlgorithm int
+
+// Values for DiffAlgorithm
+const (
+	Myers    DiffAlgorithm = iota // A shortest edit script (the default)
+	Patience                      // Anchored on lines that occur once in each text
+)
+
+// DiffOptions configures Diff and DiffString.
+type DiffOptions struct {
+	Algorithm DiffAlgorithm
+
+	// If Words is true, the changes within each modified hunk are found
+	// word by word and returned in the hunk's Words.
+	Words bool
+}
+
+// ChangeKind describes how a hunk or a line differs from an old text.
+type ChangeKind int
+
+// Values for ChangeKind
+const (
+	Unchanged ChangeKind = iota
+	Added                // Lines that are not in the old text
+	Modified             // Lines that replace lines of the old text
+	Deleted              // Lines of the old text were deleted before the line
+)
+
+// Markers shown in the last column of the gutter for lines that differ from
+// the text set by SetDiffBase
+const (
+	GutterAdded    = '+'
+	GutterModified = '~'
+	GutterDeleted  = '_'
+)
+
+// Hunk is a run of lines that differ between an old text and the buffer.
+// Lines are counted from one. A hunk that adds or deletes lines has no lines
+// on the other side, and its line there is the line before which the lines
+// would be, which is one past the last line at the end of the text.
+type Hunk struct {
+	OldLine, OldCount int // Lines of the old text
+	NewLine, NewCount int // Lines of the buffer
+
+	// Old and New are the lines of the hunk as ranges of indices, from the
+	// start of the first line to the start of the line after the last, or
+	// to the end of the text.
+	Old, New Range
+
+	// Words are the changed runs of words within the hunk, if requested.
+	Words []WordChange
+}
+
+// WordChange is a run of words that differ within a hunk. One of the ranges
+// is empty if words were only added or deleted.
+type WordChange struct {
+	Old, New Range
+}
+
+// Kind returns whether the hunk adds, modifies, or deletes lines.
+func (h Hunk) Kind() ChangeKind {
+	switch {
+	case h.OldCount == 0:
+		return Added
+	case h.NewCount == 0:
+		return Deleted
+	}
+	return Modified
+}
+
+// The incrementally updated difference between the buffer and a base text
+type diffState struct {
+	base  []string // nil if no base is set
+	algo  DiffAlgorithm
+	hunks []Hunk // Without ranges or words, sorted by line
+}
+
+// Diff compares the text of another buffer to the text of the buffer, and
+// returns the hunks in which the other buffer's text would need to be changed
+// to be the buffer's. The other buffer may be the buffer itself.
+func (t *TkText) Diff(other *TkText, opts DiffOptions) []Hunk {
+	s := other.Snapshot().t
+	old := s.lineSlice(1, s.lines.Len())
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.diffText(old, opts)
+}
+
+// DiffString is like Diff, but compares the given text to the text of the
+// buffer.
+func (t *TkText) DiffString(s string, opts DiffOptions) []Hunk {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.diffText(strings.Split(s, "\n"), opts)
+}
+
+func (t *TkText) diffText(old []string, opts DiffOptions) []Hunk {
+	lines := t.lineSlice(1, t.lines.Len())
+	hunks := diffLines(old, lines, opts.Algorithm)
+	for i := range hunks {
+		h := &hunks[i]
+		h.Old = lineRange(old, h.OldLine, h.OldCount)
+		h.New = lineRange(lines, h.NewLine, h.NewCount)
+		if opts.Words && h.Kind() == Modified {
+			h.Words = t.diffWords(old, lines, *h)
+		}
+	}
+	return hunks
+}
+
+// SetDiffBase sets the text that the lines of the buffer are compared to as it
+// is edited, such as the contents of the file on disk. Lines that differ from
+// the base are reported by LineChange, and marked in the last column of the
+// gutter with GutterAdded, GutterModified, or GutterDeleted. After an edit,
+// only the lines around the edited lines are compared again.
+func (t *TkText) SetDiffBase(base string, algo DiffAlgorithm) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.diff = diffState{strings.Split(base, "\n"), algo, nil}
+	t.diff.hunks = diffLines(t.diff.base, t.lineSlice(1, t.lines.Len()), algo)
+	t.damageAll()
+}
+
+// ClearDiffBase removes the text set by SetDiffBase, if any.
+func (t *TkText) ClearDiffBase() {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.diff = diffState{}
+	t.damageAll()
+}
+
+// DiffBaseHunks returns the hunks in which the text set by SetDiffBase differs
+// from the buffer, without words, or nil if no base is set.
+func (t *TkText) DiffBaseHunks() []Hunk {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.diff.base == nil {
+		return nil
+	}
+	lines := t.lineSlice(1, t.lines.Len())
+	hunks := make([]Hunk, len(t.diff.hunks))
+	for i, h := range t.diff.hunks {
+		h.Old = lineRange(t.diff.base, h.OldLine, h.OldCount)
+		h.New = lineRange(lines, h.NewLine, h.NewCount)
+		hunks[i] = h
+	}
+	return hunks
+}
+
+// LineChange returns how the line containing the given index differs from the
+// text set by SetDiffBase. A line before which lines of the base were deleted
+// is Deleted, as is the last line if lines were deleted at the end.
+func (t *TkText) LineChange(index string) ChangeKind {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.lineChange(t.index(index).Line)
+}
+
+func (t *TkText) lineChange(n int) ChangeKind {
+	hunks := t.diff.hunks
+	i := sort.Search(len(hunks), func(i int) bool {
+		return hunks[i].NewLine+hunks[i].NewCount > n ||
+			hunks[i].NewCount == 0 && hunks[i].NewLine >= n
+	})
+	for ; i < len(hunks) && hunks[i].NewLine <= n+1; i++ {
+		h := hunks[i]
+		switch {
+		case h.NewCount > 0 && h.NewLine <= n:
+			return h.Kind()
+		case h.NewCount == 0 && (h.NewLine == n ||
+			h.NewLine == n+1 && n == t.lines.Len()):
+			return Deleted
+		}
+	}
+	return Unchanged
+}
+
+// Compare the base again around lines first through newLast, which have just
+// replaced lines first through oldLast
+func (t *TkText) rediff(first, oldLast, newLast int) {
+	// Widen the lines to compare to include the hunks that they touch
+	a, b := first, oldLast
+	var before, after []Hunk
+	offset, merged := 0, 0 // Lines of the base less lines of the buffer
+	for _, h := range t.diff.hunks {
+		switch {
+		case h.NewLine+h.NewCount < a:
+			before = append(before, h)
+			offset += h.OldCount - h.NewCount
+		case h.NewLine > b+1:
+			after = append(after, h)
+		default:
+			a = minInt(a, h.NewLine)
+			b = maxInt(b, h.NewLine+h.NewCount-1)
+			merged += h.OldCount - h.NewCount
+		}
+	}
+	delta := newLast - oldLast
+	oldA, oldB := a+offset, b+offset+merged
+	oldB = minInt(oldB, len(t.diff.base))
+	bNew := minInt(b+delta, t.lines.Len())
+
+	hunks := append([]Hunk(nil), before...)
+	for _, h := range diffLines(t.diff.base[oldA-1:oldB],
+		t.lineSlice(a, bNew), t.diff.algo) {
+		h.OldLine += oldA - 1
+		h.NewLine += a - 1
+		hunks = append(hunks, h)
+	}
+	for _, h := range after {
+		h.NewLine += delta
+		hunks = append(hunks, h)
+	}
+	t.diff.hunks = hunks
+	if t.gutter != NoGutter {
+		t.damageLines(a, maxInt(bNew, a))
+	}
+}
+
+// Return lines first through last of the buffer
+func (t *TkText) lineSlice(first, last int) []string {
+	lines := make([]string, 0, maxInt(last-first+1, 0))
+	if first <= last {
+		t.lines.each(first, func(n int, s string) bool {
+			lines = append(lines, s)
+			return n < last
+		})
+	}
+	return lines
+}
+
+// Return the lines of a text as a range of indices
+func lineRange(lines []string, first, count int) Range {
+	pos := func(n int) Position {
+		if n > len(lines) {
+			return Position{len(lines), len(lines[len(lines)-1])}
+		}
+		return Position{n, 0}
+	}
+	return Range{pos(first), pos(first + count)}
+}
+
+// Compare two sequences of lines and return the hunks in which they differ,
+// without ranges
+func diffLines(a, b []string, algo DiffAlgorithm) []Hunk {
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		s := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			s[i] = id
+		}
+		return s
+	}
+	x, y := intern(a), intern(b)
+
+	var hunks []Hunk
+	i, j := 0, 0
+	for _, m := range matchSeqs(x, y, 0, 0, algo, nil) {
+		if m[0] > i || m[1] > j {
+			hunks = append(hunks, Hunk{OldLine: i + 1, OldCount: m[0] - i,
+				NewLine: j + 1, NewCount: m[1] - j})
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	if i < len(x) || j < len(y) {
+		hunks = append(hunks, Hunk{OldLine: i + 1, OldCount: len(x) - i,
+			NewLine: j + 1, NewCount: len(y) - j})
+	}
+	return hunks
+}
+
+// Return the changed runs of words between the old and new lines of a hunk
+func (t *TkText) diffWords(old, lines []string, h Hunk) []WordChange {
+	a := splitWords(old[h.OldLine-1:h.OldLine-1+h.OldCount], h.OldLine,
+		t.wordChars)
+	b := splitWords(lines[h.NewLine-1:h.NewLine-1+h.NewCount], h.NewLine,
+		t.wordChars)
+	ids := make(map[string]int)
+	intern := func(words []word) []int {
+		s := make([]int, len(words))
+		for i, w := range words {
+			id, ok := ids[w.s]
+			if !ok {
+				id = len(ids)
+				ids[w.s] = id
+			}
+			s[i] = id
+		}
+		return s
+	}
+
+	var changes []WordChange
+	change := func(i0, i1, j0, j1 int) {
+		if i0 < i1 || j0 < j1 {
+			changes = append(changes, WordChange{
+				Range{wordPos(a, i0), wordPos(a, i1)},
+				Range{wordPos(b, j0), wordPos(b, j1)},
+			})
+		}
+	}
+	i, j := 0, 0
+	for _, m := range matchSeqs(intern(a), intern(b), 0, 0, Myers, nil) {
+		change(i, m[0], j, m[1])
+		i, j = m[0]+1, m[1]+1
+	}
+	change(i, len(a), j, len(b))
+	return changes
+}
+
+// A word, run of whitespace, line break, or other character in a text
+type word struct {
+	s   string
+	pos Position
+}
+
+// Split lines into words, the first line being line n
+func splitWords(lines []string, n int, wordChars func(rune) bool) []word {
+	var words []word
+	class := func(r rune) int {
+		switch {
+		case wordChars(r):
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		}
+		return 0
+	}
+	for k, line := range lines {
+		if k > 0 {
+			words = append(words, word{"\n", Position{n + k - 1,
+				len(lines[k-1])}})
+		}
+		for i := 0; i < len(line); {
+			r, size := utf8.DecodeRuneInString(line[i:])
+			j := i + size
+			if c := class(r); c != 0 {
+				for j < len(line) {
+					r, size := utf8.DecodeRuneInString(line[j:])
+					if class(r) != c {
+						break
+					}
+					j += size
+				}
+			}
+			words = append(words, word{line[i:j], Position{n + k, i}})
+			i = j
+		}
+	}
+	return words
+}
+
+// Return the position of word i of a sequence, or the end of the last word
+func wordPos(words []word, i int) Position {
+	if i < len(words) {
+		return words[i].pos
+	}
+	if len(words) == 0 {
+		return Position{}
+	}
+	w := words[len(words)-1]
+	if w.s == "\n" {
+		return Position{w.pos.Line + 1, 0}
+	}
+	return Position{w.pos.Line, w.pos.Char + len(w.s)}
+}
+
+// Append the pairs of indices of matching elements of a and b, which start at
+// indices ai and bi of the whole sequences, to m in order
+func matchSeqs(a, b []int, ai, bi int, algo DiffAlgorithm,
+	m [][2]int) [][2]int {
+	// Match the common prefix and suffix
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		m = append(m, [2]int{ai, bi})
+		a, b, ai, bi = a[1:], b[1:], ai+1, bi+1
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	a, b = a[:len(a)-n], b[:len(b)-n]
+
+	if len(a) > 0 && len(b) > 0 {
+		if algo == Patience {
+			m = patience(a, b, ai, bi, m)
+		} else if x, y := bisect(a, b); x >= 0 {
+			m = matchSeqs(a[:x], b[:y], ai, bi, algo, m)
+			m = matchSeqs(a[x:], b[y:], ai+x, bi+y, algo, m)
+		}
+	}
+	for k := 0; k < n; k++ {
+		m = append(m, [2]int{ai + len(a) + k, bi + len(b) + k})
+	}
+	return m
+}
+
+// Find the middle snake of a shortest edit script from a to b, and return the
+// point at which to split the sequences, or -1, -1 if they have nothing in
+// common
+func bisect(a, b []int) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off, vlen := maxD, 2*maxD+2
+	v1, v2 := make([]int, vlen), make([]int, vlen)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0 // Whether the forward path detects the overlap
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		// Walk the forward path one step
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			k1off := off + k1
+			var x1 int
+			if k1 == -d || k1 != d && v1[k1off-1] < v1[k1off+1] {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1, y1 = x1+1, y1+1
+			}
+			v1[k1off] = x1
+			if x1 > n {
+				k1end += 2
+			} else if y1 > m {
+				k1start += 2
+			} else if front {
+				if k2off := off + delta - k1; k2off >= 0 && k2off < vlen &&
+					v2[k2off] != -1 && x1 >= n-v2[k2off] {
+					return x1, y1
+				}
+			}
+		}
+
+		// Walk the reverse path one step
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			k2off := off + k2
+			var x2 int
+			if k2 == -d || k2 != d && v2[k2off-1] < v2[k2off+1] {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2, y2 = x2+1, y2+1
+			}
+			v2[k2off] = x2
+			if x2 > n {
+				k2end += 2
+			} else if y2 > m {
+				k2start += 2
+			} else if !front {
+				if k1off := off + delta - k2; k1off >= 0 && k1off < vlen &&
+					v1[k1off] != -1 {
+					x1 := v1[k1off]
+					if y1 := off + x1 - k1off; x1 >= n-x2 {
+						return x1, y1
+					}
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Match a and b by patience diff: match the elements that occur once in each,
+// keep the longest run of them that is in the same order in both, and match
+// the sequences between them recursively, falling back to Myers' algorithm
+// where no elements are unique
+func patience(a, b []int, ai, bi int, m [][2]int) [][2]int {
+	type count struct{ a, b, bIndex int }
+	counts := make(map[int]*count)
+	for _, x := range a {
+		if counts[x] == nil {
+			counts[x] = &count{}
+		}
+		counts[x].a++
+	}
+	for j, x := range b {
+		if c := counts[x]; c != nil {
+			c.b++
+			c.bIndex = j
+		}
+	}
+	var unique [][2]int
+	for i, x := range a {
+		if c := counts[x]; c.a == 1 && c.b == 1 {
+			unique = append(unique, [2]int{i, c.bIndex})
+		}
+	}
+	if len(unique) == 0 {
+		if x, y := bisect(a, b); x >= 0 {
+			m = matchSeqs(a[:x], b[:y], ai, bi, Myers, m)
+			m = matchSeqs(a[x:], b[y:], ai+x, bi+y, Myers, m)
+		}
+		return m
+	}
+
+	i, j := 0, 0
+	for _, u := range longestIncreasing(unique) {
+		m = matchSeqs(a[i:u[0]], b[j:u[1]], ai+i, bi+j, Patience, m)
+		m = append(m, [2]int{ai + u[0], bi + u[1]})
+		i, j = u[0]+1, u[1]+1
+	}
+	return matchSeqs(a[i:], b[j:], ai+i, bi+j, Patience, m)
+}
+
+// Return the longest subsequence of pairs whose second elements increase,
+// given pairs whose first elements increase, by patience sorting
+func longestIncreasing(pairs [][2]int) [][2]int {
+	var tops []int // Index of the top pair of each pile
+	prev := make([]int, len(pairs))
+	for k, p := range pairs {
+		pile := sort.Search(len(tops), func(i int) bool {
+			return pairs[tops[i]][1] > p[1]
+		})
+		prev[k] = -1
+		if pile > 0 {
+			prev[k] = tops[pile-1]
+		}
+		if pile == len(tops) {
+			tops = append(tops, k)
+		} else {
+			tops[pile] = k
+		}
+	}
+	seq := make([][2]int, len(tops))
+	for k, i := tops[len(tops)-1], len(tops)-1; i >= 0; k, i = prev[k], i-1 {
+		seq[i] = pairs[k]
+	}
+	return seq
+}
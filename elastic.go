@@ -0,0 +1,117 @@
+package tktext
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// SetElasticTabs enables or disables elastic tabstops, following Nick
+// Gravgaard's design. With elastic tabstops, each tab ends a cell, and the
+// cells in the same column of adjacent lines form a column block that is as
+// wide as its widest cell plus one column of padding. Cells are never narrower
+// than the tab stop width set by SetTabStop, so tabs used for indentation keep
+// their usual width. Tab stops set by SetTabs and the tab style are ignored
+// while elastic tabstops are enabled. Elastic tabstops are disabled by default.
+func (t *TkText) SetElasticTabs(enabled bool) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.elastic = enabled
+	t.damageAll()
+	if enabled {
+		t.layoutElastic(1, t.lines.Len())
+	}
+	t.clampView()
+}
+
+// Return the widths in columns of the cells ended by tabs in a line
+func cellWidths(s string) []int {
+	var cells []int
+	for i := strings.IndexByte(s, '\t'); i >= 0; i = strings.IndexByte(s, '\t') {
+		cells = append(cells, utf8.RuneCountInString(s[:i]))
+		s = s[i+1:]
+	}
+	return cells
+}
+
+// Recompute the elastic tab widths of the column blocks containing lines first
+// through last
+func (t *TkText) relayoutElastic(first, last int) {
+	for first > 1 && strings.IndexByte(t.getLine(first-1), '\t') >= 0 {
+		first--
+	}
+	t.lines.each(last+1, func(n int, s string) bool {
+		if strings.IndexByte(s, '\t') < 0 {
+			return false
+		}
+		last = n
+		return true
+	})
+	t.layoutElastic(first, last)
+}
+
+// Recompute the elastic tab widths of lines first through last, which must
+// include every line of the column blocks they belong to
+func (t *TkText) layoutElastic(first, last int) {
+	var cells, old [][]int
+	t.lines.each(first, func(n int, s string) bool {
+		cells = append(cells, cellWidths(s))
+		old = append(old, t.lines.tabs(n))
+		return n < last
+	})
+
+	tabs := make([][]int, len(cells))
+	for i := range cells {
+		if len(cells[i]) > 0 {
+			tabs[i] = make([]int, len(cells[i]))
+		}
+	}
+	for col, found := 0, true; found; col++ {
+		found = false
+		for i := 0; i < len(cells); i++ {
+			if len(cells[i]) <= col {
+				continue
+			}
+
+			// Size the column block starting at line i
+			found = true
+			width, j := t.tabs.width, i
+			for ; j < len(cells) && len(cells[j]) > col; j++ {
+				if cells[j][col]+1 > width {
+					width = cells[j][col] + 1
+				}
+			}
+			for ; i < j; i++ {
+				tabs[i][col] = width - cells[i][col]
+			}
+		}
+	}
+
+	// Only replace the lines whose widths changed
+	lo, hi := 0, len(tabs)
+	for lo < hi && equalInts(tabs[lo], old[lo]) {
+		lo++
+	}
+	for hi > lo && equalInts(tabs[hi-1], old[hi-1]) {
+		hi--
+	}
+	t.lines = t.lines.setTabs(first+lo, tabs[lo:hi])
+	if lo < hi {
+		if t.wrapMode != None {
+			t.damageTail(first + lo)
+		} else {
+			t.damageLines(first+lo, first+hi-1)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package tktext
+
+import "sort"
+
+// Fold is a range of whole lines that can be hidden from the display, like
+// text with the Tk text widget's -elide tag option. When a fold is closed,
+// every line of the fold except the first is hidden; the first line remains
+// visible as the fold's header. Folds may be nested.
+type Fold struct {
+	ID int
+	Range
+	Closed bool
+}
+
+type fold struct {
+	id         int
+	start, end mark
+	closed     bool
+}
+
+// A range of lines, counting from one
+type lineSpan struct {
+	first, last int
+}
+
+// FoldAdd adds a closed fold covering the lines from that of index1 to that of
+// index2, and returns its ID. The bounds of the fold are adjusted as the text
+// is edited, and the fold is removed if its lines are joined into one. If both
+// indices are on the same line, no fold is added and zero is returned.
+func (t *TkText) FoldAdd(index1, index2 string) int {
+	t.mutex.Lock()
+	defer t.unlock()
+	pos1, pos2 := t.index(index1), t.index(index2)
+	if pos1.Line > pos2.Line {
+		pos1, pos2 = pos2, pos1
+	}
+	if pos1.Line == pos2.Line {
+		return 0
+	}
+	t.foldID++
+	t.folds = append(t.folds, fold{
+		t.foldID,
+		mark{Position{pos1.Line, 0}, Right, ""},
+		mark{Position{pos2.Line, len(t.getLine(pos2.Line))}, Left, ""},
+		true,
+	})
+	t.updateElided()
+	return t.foldID
+}
+
+// FoldRemove removes the folds with the given IDs, if they exist. Folds nested
+// in removed folds are unaffected.
+func (t *TkText) FoldRemove(id ...int) {
+	t.mutex.Lock()
+	defer t.unlock()
+	for _, v := range id {
+		for i, f := range t.folds {
+			if f.id == v {
+				t.folds = append(t.folds[:i:i], t.folds[i+1:]...)
+				break
+			}
+		}
+	}
+	t.updateElided()
+}
+
+// FoldSetClosed closes or opens the fold with the given ID. Returns false if
+// and only if there is no such fold.
+func (t *TkText) FoldSetClosed(id int, closed bool) bool {
+	t.mutex.Lock()
+	defer t.unlock()
+	for i := range t.folds {
+		if t.folds[i].id == id {
+			t.folds[i].closed = closed
+			t.updateElided()
+			return true
+		}
+	}
+	return false
+}
+
+// FoldToggle opens the fold with the given ID if it is closed, and closes it
+// if it is open. Returns false if and only if there is no such fold.
+func (t *TkText) FoldToggle(id int) bool {
+	t.mutex.Lock()
+	defer t.unlock()
+	for i := range t.folds {
+		if t.folds[i].id == id {
+			t.folds[i].closed = !t.folds[i].closed
+			t.updateElided()
+			return true
+		}
+	}
+	return false
+}
+
+// Folds returns the folds in the buffer, ordered by their first lines, with
+// enclosing folds before the folds nested in them. The range of each fold
+// extends from the start of its first line to the end of its last line.
+func (t *TkText) Folds() []Fold {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.foldList()
+}
+
+func (t *TkText) foldList() []Fold {
+	folds := make([]Fold, len(t.folds))
+	for i, f := range t.folds {
+		folds[i] = Fold{f.id, Range{Position{f.start.Line, 0},
+			Position{f.end.Line, len(t.getLine(f.end.Line))}}, f.closed}
+	}
+	sort.SliceStable(folds, func(i, j int) bool {
+		if folds[i].Start.Line != folds[j].Start.Line {
+			return folds[i].Start.Line < folds[j].Start.Line
+		}
+		return folds[i].End.Line > folds[j].End.Line
+	})
+	return folds
+}
+
+// Elided returns true if and only if the line containing the given index is
+// hidden by a closed fold.
+func (t *TkText) Elided(index string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.lineHidden(t.index(index).Line)
+}
+
+// Remove folds whose lines have been joined into one, and recompute the hidden
+// lines
+func (t *TkText) updateFolds() {
+	folds := t.folds[:0]
+	for _, f := range t.folds {
+		if f.start.Line < f.end.Line {
+			folds = append(folds, f)
+		}
+	}
+	t.folds = folds
+	t.updateElided()
+}
+
+// Recompute the sorted, merged spans of lines hidden by closed folds
+func (t *TkText) updateElided() {
+	old := t.elided
+	var spans []lineSpan
+	for _, f := range t.folds {
+		if f.closed && f.start.Line < f.end.Line {
+			spans = append(spans, lineSpan{f.start.Line + 1, f.end.Line})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].first < spans[j].first
+	})
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.first <= merged[n-1].last+1 {
+			if s.last > merged[n-1].last {
+				merged[n-1].last = s.last
+			}
+		} else {
+			merged = append(merged, s)
+		}
+	}
+	t.elided = merged
+	t.damageElided(old, merged)
+	if len(old) != len(merged) {
+		t.unclamped = true
+	}
+	for i := 0; i < len(old) && i < len(merged); i++ {
+		if old[i] != merged[i] {
+			t.unclamped = true
+		}
+	}
+}
+
+// Return the index of the first hidden span that ends at or after line n
+func (t *TkText) elidedSpan(n int) int {
+	return sort.Search(len(t.elided), func(i int) bool {
+		return t.elided[i].last >= n
+	})
+}
+
+// Report whether line n is hidden by a closed fold
+func (t *TkText) lineHidden(n int) bool {
+	i := t.elidedSpan(n)
+	return i < len(t.elided) && t.elided[i].first <= n
+}
+
+// Return line n if it is visible, or else the header line of the fold that
+// hides it
+func (t *TkText) visibleLine(n int) int {
+	if i := t.elidedSpan(n); i < len(t.elided) && t.elided[i].first <= n {
+		return t.elided[i].first - 1
+	}
+	return n
+}
+
+// Return the position itself if it is visible, or else the end of the header
+// line of the fold that hides it
+func (t *TkText) visiblePos(pos Position) Position {
+	if n := t.visibleLine(pos.Line); n != pos.Line {
+		return Position{n, len(t.getLine(n))}
+	}
+	return pos
+}
+
+// Return the first visible line after line n
+func (t *TkText) nextVisibleLine(n int) int {
+	n++
+	if i := t.elidedSpan(n); i < len(t.elided) && t.elided[i].first <= n {
+		n = t.elided[i].last + 1
+	}
+	return n
+}
+
+// Return the line number of the visible line with the given zero-based index,
+// which may be past the end of the buffer
+func (t *TkText) lineAtRow(row int) int {
+	n := row + 1
+	for _, s := range t.elided {
+		if s.first > n {
+			break
+		}
+		n += s.last - s.first + 1
+	}
+	return n
+}
+
+// Return the zero-based index of visible line n among the visible lines, the
+// inverse of lineAtRow
+func (t *TkText) rowOfLine(n int) int {
+	row := n - 1
+	for _, s := range t.elided {
+		if s.first > n {
+			break
+		}
+		row -= minInt(s.last, n-1) - s.first + 1
+	}
+	return row
+}
@@ -0,0 +1,281 @@
+package tktext
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// A FoldProvider computes the foldable regions of a buffer from its contents.
+// Like a Tokenizer, a provider scans the buffer a line at a time, carrying a
+// state from the end of each line to the start of the next, so that regions
+// can be updated incrementally as the buffer is edited: only the edited lines,
+// and the lines after them whose starting states have changed, are rescanned.
+// As with a Tokenizer, states are compared with reflect.DeepEqual, and a
+// provider must not modify the states it is given.
+type FoldProvider interface {
+	// ScanLine scans a line, given the state at the end of the line before
+	// it, or nil for the first line, and returns the regions found at the
+	// line and the state at its end. Each region is given by the numbers of
+	// lines from its first and last lines to the scanned line, so that
+	// regions found before an edit are moved with the lines after it.
+	// Regions may be nested.
+	ScanLine(line string, state interface{}) (regions [][2]int,
+		end interface{})
+
+	// Finish returns the regions that are found at the end of the buffer,
+	// given the state at the end of its last line, as numbers of lines back
+	// from the last line.
+	Finish(state interface{}) [][2]int
+}
+
+// The state of a built-in fold provider: a stack of the lines at which the
+// regions that are open at the end of a line start. Lines are counted back
+// from the line above, so that the stack is unchanged by lines inserted or
+// deleted before the regions it holds.
+type foldStack struct {
+	frames []foldFrame
+	gap    int // Lines from the line of the top frame to the scanned line
+}
+
+type foldFrame struct {
+	key   int // Indentation or opening bracket of the region
+	delta int // Lines from the frame below, or zero for the bottom frame
+}
+
+// Return the stack at the start of the next line
+func (s foldStack) next() foldStack {
+	if len(s.frames) > 0 {
+		s.gap++
+	}
+	return s
+}
+
+// Return the stack with a region starting at the scanned line pushed onto it
+func (s foldStack) push(key int) foldStack {
+	n := len(s.frames)
+	delta := s.gap
+	if n == 0 {
+		delta = 0
+	}
+	s.frames = append(s.frames[:n:n], foldFrame{key, delta})
+	s.gap = 0
+	return s
+}
+
+// Return the stack with its top frame popped
+func (s foldStack) pop() foldStack {
+	n := len(s.frames)
+	s.gap += s.frames[n-1].delta
+	s.frames = s.frames[:n-1]
+	if n == 1 {
+		s.frames, s.gap = nil, 0
+	}
+	return s
+}
+
+type indentFolds struct {
+	tabStop int
+}
+
+// IndentFolds returns a FoldProvider whose regions are determined by
+// indentation, as in Python or YAML. A region starts at each line that is
+// followed by a more indented line, and ends at the last line before the next
+// line that is indented no more than the first. Blank lines do not end regions
+// and are not included at their ends. Tabs are counted as advancing to the
+// next multiple of tabStop columns. Panics if tabStop is not positive.
+func IndentFolds(tabStop int) FoldProvider {
+	if tabStop < 1 {
+		panic(errors.New("Bad tab stop width: " + strconv.Itoa(tabStop)))
+	}
+	return indentFolds{tabStop}
+}
+
+// Return the indentation of a line in columns, or -1 if it is blank
+func (p indentFolds) indent(line string) int {
+	col := 0
+	for _, ch := range line {
+		switch ch {
+		case ' ':
+			col++
+		case '\t':
+			col += p.tabStop - col%p.tabStop
+		case '\r', '\n', '\f', '\v':
+		default:
+			return col
+		}
+	}
+	return -1
+}
+
+// Each non-blank line is pushed onto the stack, so the top frame is always the
+// last non-blank line
+func (p indentFolds) ScanLine(line string, state interface{}) ([][2]int,
+	interface{}) {
+	s, _ := state.(foldStack)
+	s = s.next()
+	indent := p.indent(line)
+	if indent < 0 {
+		return nil, s
+	}
+	regions, s := p.close(s, indent)
+	return regions, s.push(indent)
+}
+
+func (p indentFolds) Finish(state interface{}) [][2]int {
+	s, _ := state.(foldStack)
+	regions, _ := p.close(s, -1)
+	return regions
+}
+
+// Pop the regions that are indented at least as far as a line, which end at
+// the last non-blank line before it
+func (p indentFolds) close(s foldStack, indent int) ([][2]int, foldStack) {
+	var regions [][2]int
+	last := s.gap
+	for n := len(s.frames); n > 0 && s.frames[n-1].key >= indent; n-- {
+		if s.gap > last {
+			regions = append(regions, [2]int{s.gap, last})
+		}
+		s = s.pop()
+	}
+	return regions, s
+}
+
+type bracketFolds struct {
+	closers map[rune]rune // Maps closing brackets to opening brackets
+}
+
+// BracketFolds returns a FoldProvider whose regions extend from the line of
+// each opening bracket to the line of its matching closing bracket. Each pair
+// is a string of an opening and a closing bracket; the default pairs are
+// "()", "[]", and "{}". Brackets in strings and comments are not skipped.
+// Panics if a pair is not exactly two different characters.
+func BracketFolds(pairs ...string) FoldProvider {
+	if len(pairs) == 0 {
+		pairs = []string{"()", "[]", "{}"}
+	}
+	p := bracketFolds{make(map[rune]rune)}
+	for _, pair := range parsePairs(pairs) {
+		p.closers[pair[1]] = pair[0]
+	}
+	return p
+}
+
+func (p bracketFolds) isOpener(r rune) bool {
+	for _, v := range p.closers {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Each opening bracket is pushed onto the stack, and popped by its closing
+// bracket
+func (p bracketFolds) ScanLine(line string, state interface{}) ([][2]int,
+	interface{}) {
+	var regions [][2]int
+	s, _ := state.(foldStack)
+	s = s.next()
+	for _, ch := range line {
+		if open, ok := p.closers[ch]; ok {
+			n := len(s.frames)
+			if n == 0 || rune(s.frames[n-1].key) != open {
+				continue // Unmatched closing bracket
+			}
+			if s.gap > 0 {
+				regions = append(regions, [2]int{s.gap, 0})
+			}
+			s = s.pop()
+		} else if p.isOpener(ch) {
+			s = s.push(int(ch))
+		}
+	}
+	return regions, s
+}
+
+// Unmatched opening brackets do not start regions
+func (p bracketFolds) Finish(state interface{}) [][2]int {
+	return nil
+}
+
+// SetFoldProvider sets the provider used to compute the buffer's foldable
+// regions, which are kept up to date as the buffer is edited. A nil provider
+// disables the computation of regions. No provider is set by default.
+func (t *TkText) SetFoldProvider(p FoldProvider) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.foldProvider = p
+	if p != nil {
+		t.rescanFolds(1, t.lines.Len())
+	}
+}
+
+// FoldRegions returns the foldable regions computed by the buffer's fold
+// provider, ordered by their first lines, with enclosing regions before the
+// regions nested in them. Each region extends from the start of its first line
+// to the end of its last line, and can be passed to FoldAdd.
+func (t *TkText) FoldRegions() []Range {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.foldRegions()
+}
+
+// The results of scanning a line with the fold provider
+type foldLine struct {
+	start, end interface{} // States at the start and end of the line
+	regions    [][2]int    // Regions found at the line
+}
+
+// Scan lines from line first onward with the fold provider, until the state at
+// the start of a line after line last is the same as it was before the lines
+// were edited
+func (t *TkText) rescanFolds(first, last int) {
+	var state interface{}
+	if first > 1 {
+		state = t.lines.entry(first - 1).fold.end
+	}
+	var entries []lineEntry
+	t.lines.eachEntry(first, func(n int, e lineEntry) bool {
+		if n > last && e.fold != nil && reflect.DeepEqual(e.fold.start, state) {
+			return false
+		}
+		regions, end := t.foldProvider.ScanLine(e.s, state)
+		e.fold = &foldLine{state, end, regions}
+		entries = append(entries, e)
+		state = end
+		return true
+	})
+	t.lines = t.lines.replace(first-1, first-1+len(entries), entries)
+}
+
+// Collect the foldable regions found at each line
+func (t *TkText) foldRegions() []Range {
+	if t.foldProvider == nil {
+		return nil
+	}
+	var regions []Range
+	add := func(n int, found [][2]int) {
+		for _, r := range found {
+			last := n - r[1]
+			regions = append(regions, Range{Position{n - r[0], 0},
+				Position{last, len(t.getLine(last))}})
+		}
+	}
+	var state interface{}
+	t.lines.eachEntry(1, func(n int, e lineEntry) bool {
+		add(n, e.fold.regions)
+		state = e.fold.end
+		return true
+	})
+	add(t.lines.Len(), t.foldProvider.Finish(state))
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Start.Line != regions[j].Start.Line {
+			return regions[i].Start.Line < regions[j].Start.Line
+		}
+		return regions[i].End.Line > regions[j].End.Line
+	})
+	return regions
+}
@@ -0,0 +1,115 @@
+package tktext
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GutterMode determines which line numbers are shown in the gutter to the
+// left of the text display.
+type GutterMode int
+
+// Values for GutterMode
+const (
+	NoGutter        GutterMode = iota // No gutter is shown (the default)
+	AbsoluteNumbers                   // Line numbers in the buffer
+	RelativeNumbers                   // Visible lines from the insert mark's line
+	HybridNumbers                     // Relative, but absolute on the insert line
+)
+
+// GutterContinuation is the marker shown in the gutter on display lines that
+// continue a wrapped line.
+const GutterContinuation = '↪'
+
+// SetGutter sets which line numbers are shown in the gutter. The gutter is
+// wide enough for the largest line number in the buffer, followed by a space,
+// and its width is taken from the width set by SetSize, leaving the rest to
+// the text display. Relative numbers count the visible lines between each line
+// and the line of the insert mark, so a closed fold counts as one line.
+func (t *TkText) SetGutter(mode GutterMode) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.gutter = mode
+	t.layoutWidth()
+}
+
+// GutterWidth returns the width of the gutter in columns, or in the units of
+// the buffer's Measurer if one is set, or zero if no gutter is shown.
+func (t *TkText) GutterWidth() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.gutterWidth()
+}
+
+func (t *TkText) gutterWidth() int {
+	if t.gutter == NoGutter {
+		return 0
+	}
+	digits := len(strconv.Itoa(t.lines.Len()))
+	return t.measurer.StringWidth(strings.Repeat("0", digits) + " ")
+}
+
+// Set the width of the text display to what is left of the display width
+// beside the gutter
+func (t *TkText) layoutWidth() {
+	if t.width = t.viewWidth - t.gutterWidth(); t.width < 0 {
+		t.width = 0
+	}
+}
+
+// GetScreenGutter returns the gutter of each display line on the screen,
+// corresponding to the strings returned by GetScreenLines. Each is as wide as
+// the gutter, and contains the line number of the display line right-aligned
+// and followed by a space, or GutterContinuation in place of the number if the
+// display line continues a wrapped line. If a diff base is set with
+// SetDiffBase, the space is replaced by a marker on lines that differ from it.
+// If no gutter is shown, no strings are returned.
+func (t *TkText) GetScreenGutter() []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.getScreenGutter()
+}
+
+func (t *TkText) getScreenGutter() []string {
+	if t.gutter == NoGutter {
+		return nil
+	}
+	width := t.gutterWidth()
+	insert := 1
+	if m := t.marks[insertMark]; m != nil {
+		insert = t.visibleLine(m.Line)
+	}
+	screen := t.screenLines()
+	gutter := make([]string, len(screen))
+	for i, sl := range screen {
+		var label string
+		continued := sl.offset > 0 && t.wrapMode != None
+		switch {
+		case continued:
+			label = string(GutterContinuation)
+		case t.gutter == AbsoluteNumbers,
+			t.gutter == HybridNumbers && sl.line == insert:
+			label = strconv.Itoa(sl.line)
+		default:
+			n := t.rowOfLine(sl.line) - t.rowOfLine(insert)
+			if n < 0 {
+				n = -n
+			}
+			label = strconv.Itoa(n)
+		}
+		marker := ' '
+		if t.diff.base != nil && !continued {
+			switch t.lineChange(sl.line) {
+			case Added:
+				marker = GutterAdded
+			case Modified:
+				marker = GutterModified
+			case Deleted:
+				marker = GutterDeleted
+			}
+		}
+		pad := width - 1 - len([]rune(label))
+		gutter[i] = strings.Repeat(" ", pad) + label + string(marker)
+	}
+	return gutter
+}
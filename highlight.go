@@ -0,0 +1,100 @@
+package tktext
+
+import "reflect"
+
+// The cached results of lexing a line
+type lexLine struct {
+	start, end interface{} // Tokenizer states at the start and end of the line
+	tokens     []Token
+}
+
+// StyleRun is a span of a display line that is drawn in a style. Start and End
+// are byte offsets into the display line, and Style is the kind of the token
+// that the span is part of.
+type StyleRun struct {
+	Start, End int
+	Style      string
+}
+
+// Lex lines from line first onward, until the tokenizer's state at the start
+// of a line after line last is the same as it was before the lines were
+// edited
+func (t *TkText) relex(first, last int) {
+	var state interface{}
+	if first > 1 {
+		state = t.lines.entry(first - 1).lex.end
+	}
+	var entries []lineEntry
+	t.lines.eachEntry(first, func(n int, e lineEntry) bool {
+		if n > last && e.lex != nil &&
+			reflect.DeepEqual(e.lex.start, state) {
+			return false
+		}
+		tokens, end := t.tokenizer.Tokenize(e.s, state)
+		e.lex, e.stat = &lexLine{state, end, tokens}, nil
+		entries = append(entries, e)
+		state = end
+		return true
+	})
+	t.lines = t.lines.replace(first-1, first-1+len(entries), entries)
+	if len(entries) > 0 {
+		t.damageLines(first, first+len(entries)-1)
+	}
+}
+
+// Return the tokens of line n
+func (t *TkText) lineTokens(n int) []Token {
+	if t.tokenizer == nil {
+		return nil
+	}
+	if lex := t.lines.entry(n).lex; lex != nil {
+		return lex.tokens
+	}
+	return nil
+}
+
+// GetScreenStyles returns a slice of style runs for each display line on the
+// screen, corresponding to the strings returned by GetScreenLines. The runs
+// are the parts of tokens of the buffer's tokenizer that are on the screen,
+// excluding tokens whose kind is empty. If no tokenizer is set, no runs are
+// returned.
+func (t *TkText) GetScreenStyles() [][]StyleRun {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.getScreenStyles()
+}
+
+func (t *TkText) getScreenStyles() [][]StyleRun {
+	screen := t.screenLines()
+	styles := make([][]StyleRun, len(screen))
+	if t.tokenizer == nil {
+		return styles
+	}
+	for i, sl := range screen {
+		for _, tok := range t.lineTokens(sl.line) {
+			if tok.Kind == "" {
+				continue
+			}
+			if start, end := t.screenSpan(sl, tok.Start, tok.End); start < end {
+				styles[i] = append(styles[i], StyleRun{start, end, tok.Kind})
+			}
+		}
+	}
+	return styles
+}
+
+// Return the byte offsets in a display line of the text of its buffer line
+// from byte start to byte end, clipped to the display line
+func (t *TkText) screenSpan(sl screenLine, start, end int) (int, int) {
+	s := t.getLine(sl.line)
+	widths := t.tabWidths(sl.line, s)
+	start = len(expand(s[:start], widths)) - sl.offset
+	end = len(expand(s[:end], widths)) - sl.offset
+	if start < 0 {
+		start = 0
+	}
+	if end > len(sl.text) {
+		end = len(sl.text)
+	}
+	return start, end
+}
@@ -0,0 +1,134 @@
+package tktext
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Measurer measures text in the font used to display a buffer, so that the
+// buffer can be laid out in pixels rather than in character cells.
+type Measurer interface {
+	// RuneWidth returns the advance width of a rune.
+	RuneWidth(r rune) int
+
+	// StringWidth returns the advance width of a string, which must be the
+	// sum of the widths of its runes.
+	StringWidth(s string) int
+
+	// LineHeight returns the height of a line of text.
+	LineHeight() int
+}
+
+// The default Measurer, which lays text out in character cells
+type cellMeasurer struct{}
+
+func (cellMeasurer) RuneWidth(r rune) int {
+	return 1
+}
+
+func (cellMeasurer) StringWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func (cellMeasurer) LineHeight() int {
+	return 1
+}
+
+// SetMeasurer sets the Measurer used to lay out the text display. When a
+// measurer is set, coordinates are in its units rather than in characters and
+// lines: this includes the width and height set by SetSize, the x and y
+// coordinates and heights returned by BBox and DLineInfo and accepted in
+// "@x,y" indices, line spacing, and the horizontal and vertical scrolling of
+// the view. Lines are wrapped where they exceed the width of the display, and
+// tabs are measured as the spaces they expand to. A nil measurer restores the
+// default, which measures every character as one unit wide and one unit high.
+func (t *TkText) SetMeasurer(m Measurer) {
+	if m == nil {
+		m = cellMeasurer{}
+	}
+	t.mutex.Lock()
+	defer t.unlock()
+	t.measurer = m
+	t.layoutWidth()
+	t.damageAll()
+	t.clampView()
+}
+
+// The layout of a buffer line on the display
+type lineLayout struct {
+	text   string // Text of the line, with tabs expanded
+	starts []int  // Byte offsets in text at which display lines start
+}
+
+// Lay out line n, whose contents are s
+func (t *TkText) layoutLine(n int, s string) lineLayout {
+	l := lineLayout{expand(s, t.tabWidths(n, s)), []int{0}}
+	if t.wrapMode == None || t.width <= 0 {
+		return l
+	}
+	x, start, brk := 0, 0, 0 // brk is the offset after the last space
+	for i, ch := range l.text {
+		w := t.measurer.RuneWidth(ch)
+		space := unicode.IsSpace(ch)
+		if x+w > t.width && i > start && !(t.wrapMode == Word && space) {
+			start = i
+			if t.wrapMode == Word && brk > l.starts[len(l.starts)-1] {
+				start = brk
+			}
+			l.starts = append(l.starts, start)
+			x = t.measurer.StringWidth(l.text[start:i])
+		}
+		x += w
+		if space {
+			brk = i + utf8.RuneLen(ch)
+		}
+	}
+	return l
+}
+
+// Return the index of the display line containing byte i of the text
+func (l lineLayout) row(i int) int {
+	return sort.SearchInts(l.starts, i+1) - 1
+}
+
+// Return the text of display line r
+func (l lineLayout) rowText(r int) string {
+	if r+1 < len(l.starts) {
+		return l.text[l.starts[r]:l.starts[r+1]]
+	}
+	return l.text[l.starts[r]:]
+}
+
+// Return the byte offsets of the runes of s that lie at least partly between
+// positions x0 and x1
+func (t *TkText) clip(s string, x0, x1 int) (int, int) {
+	x, start, end := 0, len(s), len(s)
+	for i, ch := range s {
+		w := t.measurer.RuneWidth(ch)
+		if x+w > x0 && start == len(s) {
+			start = i
+		}
+		if x >= x1 {
+			end = i
+			break
+		}
+		x += w
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// Return the byte offset of the rune of s that covers position x, or the
+// length of s if x is past its end
+func (t *TkText) runeCovering(s string, x int) int {
+	cur := 0
+	for i, ch := range s {
+		if cur += t.measurer.RuneWidth(ch); cur > x {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,189 @@
+package tktext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var stateNameRegexp = regexp.MustCompile(`^\[(\w+)\]$`)
+
+type lexRule struct {
+	re     *regexp.Regexp // Matches at the start of a line
+	after  *regexp.Regexp // Matches after the rune before a position
+	kind   string
+	push   string // Name of state to push, if any
+	pop    bool
+	source int // Line number of the rule in the spec
+}
+
+// A Tokenizer defined by regular expression rules grouped into states
+type ruleLexer struct {
+	initial string
+	states  map[string][]lexRule
+}
+
+// ParseLexer parses a lexer definition and returns a Tokenizer that lexes text
+// according to it. A definition is a list of states, each introduced by a line
+// containing its name in brackets, followed by lines containing the rules of
+// the state. Blank lines and lines starting with # are ignored. The first state
+// is the initial state.
+//
+// A rule consists of a regular expression, the kind of the tokens it matches
+// (or "-" for text that is not a token), and optionally an action: ">name"
+// pushes the named state onto the lexer's stack of states, and "<" pops the
+// current state. Fields are separated by whitespace, so whitespace in a regular
+// expression must be written as \s or \x20. For example:
+//
+//	[code]
+//	"([^"\\]|\\.)*"   string
+//	//.*              comment
+//	/\*               comment  >comment
+//	\b(if|else)\b     keyword
+//	[comment]
+//	\*/               comment  <
+//	.                 comment
+//
+// At each position in a line, the rules of the current state are tried in
+// order, and the first rule that matches a non-empty string is applied. If no
+// rule matches, the character at the position is skipped. Rules are matched in
+// the context of the whole line, so ^ matches only at its start, and \b only
+// at word boundaries. The stack of states is carried from line to line.
+func ParseLexer(spec string) (Tokenizer, error) {
+	l := &ruleLexer{states: make(map[string][]lexRule)}
+	state := ""
+	for i, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match := stateNameRegexp.FindStringSubmatch(line); match != nil {
+			state = match[1]
+			if l.initial == "" {
+				l.initial = state
+			}
+			if _, ok := l.states[state]; ok {
+				return nil, fmt.Errorf("line %d: duplicate state %s", i+1, state)
+			}
+			l.states[state] = nil
+			continue
+		}
+		if state == "" {
+			return nil, fmt.Errorf("line %d: rule outside of a state", i+1)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: bad rule: %s", i+1, line)
+		}
+		re, err := regexp.Compile(`^(?:` + fields[0] + `)`)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		after := regexp.MustCompile(`^(?s:.)(` + fields[0] + `)`)
+		rule := lexRule{re: re, after: after, kind: fields[1], source: i + 1}
+		if rule.kind == "-" {
+			rule.kind = ""
+		}
+		if len(fields) == 3 {
+			switch action := fields[2]; {
+			case action == "<":
+				rule.pop = true
+			case strings.HasPrefix(action, ">") && len(action) > 1:
+				rule.push = action[1:]
+			default:
+				return nil, fmt.Errorf("line %d: bad action: %s", i+1, action)
+			}
+		}
+		l.states[state] = append(l.states[state], rule)
+	}
+
+	if l.initial == "" {
+		return nil, fmt.Errorf("no states defined")
+	}
+	for _, rules := range l.states {
+		for _, rule := range rules {
+			if _, ok := l.states[rule.push]; rule.push != "" && !ok {
+				return nil, fmt.Errorf("line %d: undefined state %s",
+					rule.source, rule.push)
+			}
+		}
+	}
+	return l, nil
+}
+
+// Tokenize lexes a line. The state is the stack of states above the initial
+// state, joined by slashes, or nil if the stack contains only the initial
+// state.
+func (l *ruleLexer) Tokenize(line string,
+	state interface{}) ([]Token, interface{}) {
+	var stack []string
+	if s, ok := state.(string); ok {
+		stack = strings.Split(s, "/")
+	}
+
+	var tokens []Token
+	for i := 0; i < len(line); {
+		current := l.initial
+		if len(stack) > 0 {
+			current = stack[len(stack)-1]
+		}
+		matched := false
+		for _, rule := range l.states[current] {
+			n := rule.match(line, i)
+			if n == 0 {
+				continue
+			}
+			tokens = appendToken(tokens, Token{i, i + n, rule.kind})
+			if rule.push != "" {
+				stack = append(stack, rule.push)
+			} else if rule.pop && len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			i += n
+			matched = true
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(line[i:])
+			i += size
+		}
+	}
+
+	if len(stack) == 0 {
+		return tokens, nil
+	}
+	return tokens, strings.Join(stack, "/")
+}
+
+// Return the length of the rule's match at byte i of a line, or zero if it
+// does not match there. Regular expressions cannot look behind a position, so
+// the rune before it is the only context needed for ^ and \b.
+func (r lexRule) match(line string, i int) int {
+	if i == 0 {
+		if loc := r.re.FindStringIndex(line); loc != nil {
+			return loc[1]
+		}
+		return 0
+	}
+	_, size := utf8.DecodeLastRuneInString(line[:i])
+	if loc := r.after.FindStringSubmatchIndex(line[i-size:]); loc != nil {
+		return loc[3] - size
+	}
+	return 0
+}
+
+// Append a token to a list, merging it into the last token if they are
+// adjacent and of the same kind. Tokens of empty kind are not appended.
+func appendToken(tokens []Token, tok Token) []Token {
+	if tok.Kind == "" {
+		return tokens
+	}
+	if n := len(tokens); n > 0 && tokens[n-1].End == tok.Start &&
+		tokens[n-1].Kind == tok.Kind {
+		tokens[n-1].End = tok.End
+		return tokens
+	}
+	return append(tokens, tok)
+}
@@ -0,0 +1,163 @@
+package tktext
+
+// Maximum number of lines in a chunk of a lineStore
+const chunkSize = 128
+
+// lineStore is an immutable sequence of lines, stored in chunks of bounded
+// size. Splicing returns a new lineStore that shares every chunk it does not
+// modify with the original, so copies are cheap and unaffected by later edits.
+type lineStore struct {
+	chunks [][]lineEntry
+	n      int
+}
+
+// A line and state derived from it: the cached widths of its tabs, if elastic
+// tabstops are in use, the results of lexing it, if a tokenizer is set, the
+// results of scanning it, if a fold provider is set, and its minimap
+// statistics, once they have been requested
+type lineEntry struct {
+	s    string
+	tabs []int
+	lex  *lexLine
+	fold *foldLine
+	stat *lineStat
+}
+
+func newLineStore(lines ...string) lineStore {
+	return lineStore{}.splice(0, 0, lines)
+}
+
+// Len returns the number of lines in the store.
+func (l lineStore) Len() int {
+	return l.n
+}
+
+// Return the index of the chunk containing the line with the given zero-based
+// index, and the index of the first line in that chunk
+func (l lineStore) find(i int) (int, int) {
+	start := 0
+	for ci, chunk := range l.chunks {
+		if i < start+len(chunk) {
+			return ci, start
+		}
+		start += len(chunk)
+	}
+	return len(l.chunks), start
+}
+
+// Return line n, counting from one
+func (l lineStore) line(n int) string {
+	ci, start := l.find(n - 1)
+	return l.chunks[ci][n-1-start].s
+}
+
+// Return the entry for line n, counting from one
+func (l lineStore) entry(n int) lineEntry {
+	ci, start := l.find(n - 1)
+	return l.chunks[ci][n-1-start]
+}
+
+// Return the cached tab widths of line n, counting from one
+func (l lineStore) tabs(n int) []int {
+	return l.entry(n).tabs
+}
+
+// Return the last line
+func (l lineStore) last() string {
+	chunk := l.chunks[len(l.chunks)-1]
+	return chunk[len(chunk)-1].s
+}
+
+// Call f with each line from line n onward, counting from one, until f returns
+// false or there are no more lines
+func (l lineStore) each(n int, f func(n int, s string) bool) {
+	l.eachEntry(n, func(n int, e lineEntry) bool {
+		return f(n, e.s)
+	})
+}
+
+// Call f with the entry for each line from line n onward, counting from one,
+// until f returns false or there are no more lines
+func (l lineStore) eachEntry(n int, f func(n int, e lineEntry) bool) {
+	ci, start := l.find(n - 1)
+	i := n - 1 - start
+	for ; ci < len(l.chunks); ci++ {
+		for ; i < len(l.chunks[ci]); i++ {
+			if !f(n, l.chunks[ci][i]) {
+				return
+			}
+			n++
+		}
+		i = 0
+	}
+}
+
+// Return a store in which the lines with zero-based indices in [i, j) are
+// replaced by the given lines
+func (l lineStore) splice(i, j int, lines []string) lineStore {
+	entries := make([]lineEntry, len(lines))
+	for k, s := range lines {
+		entries[k].s = s
+	}
+	return l.replace(i, j, entries)
+}
+
+// Return a store in which the cached tab widths of successive lines starting
+// at line n, counting from one, are replaced by the given widths
+func (l lineStore) setTabs(n int, tabs [][]int) lineStore {
+	if len(tabs) == 0 {
+		return l
+	}
+	entries := make([]lineEntry, len(tabs))
+	l.eachEntry(n, func(k int, e lineEntry) bool {
+		e.tabs = tabs[k-n]
+		entries[k-n] = e
+		return k-n+1 < len(tabs)
+	})
+	return l.replace(n-1, n-1+len(tabs), entries)
+}
+
+// Return a store in which the entries with zero-based indices in [i, j) are
+// replaced by the given entries
+func (l lineStore) replace(i, j int, lines []lineEntry) lineStore {
+	// Find the chunks spanning the affected lines
+	ci, start := l.find(i)
+	if ci == len(l.chunks) && ci > 0 {
+		ci--
+		start -= len(l.chunks[ci])
+	}
+	cj := ci + 1
+	if j > i {
+		last, _ := l.find(j - 1)
+		cj = last + 1
+	}
+	if cj > len(l.chunks) {
+		cj = len(l.chunks)
+	}
+
+	// Gather the affected lines, merging a small result into the next chunk
+	var old []lineEntry
+	for _, chunk := range l.chunks[ci:cj] {
+		old = append(old, chunk...)
+	}
+	if len(old)-(j-i)+len(lines) < chunkSize/2 && cj < len(l.chunks) {
+		old = append(old, l.chunks[cj]...)
+		cj++
+	}
+	edited := make([]lineEntry, 0, len(old)-(j-i)+len(lines))
+	edited = append(edited, old[:i-start]...)
+	edited = append(edited, lines...)
+	edited = append(edited, old[j-start:]...)
+
+	// Split the edited lines into evenly sized chunks
+	pieces := (len(edited) + chunkSize - 1) / chunkSize
+	chunks := make([][]lineEntry, 0, len(l.chunks)-(cj-ci)+pieces)
+	chunks = append(chunks, l.chunks[:ci]...)
+	for p := 0; p < pieces; p++ {
+		chunks = append(chunks,
+			edited[p*len(edited)/pieces:(p+1)*len(edited)/pieces])
+	}
+	chunks = append(chunks, l.chunks[cj:]...)
+
+	return lineStore{chunks, l.n - (j - i) + len(lines)}
+}
@@ -0,0 +1,170 @@
+package tktext
+
+import (
+	"sort"
+	"unicode"
+)
+
+// MinimapRow summarizes a range of lines in the buffer for a row of a minimap.
+type MinimapRow struct {
+	First, Last int     // Lines covered by the row, counting from one
+	Density     float64 // Proportion of the characters that are not whitespace
+	Style       string  // Kind of token with the most non-whitespace characters
+}
+
+// Minimap is a downsampled summary of the whole buffer, for drawing an
+// overview of the document beside the text display.
+type Minimap struct {
+	Rows []MinimapRow
+
+	// Top and Bottom are the fractions returned by YView, and TopRow and
+	// BottomRow are the indices of the rows covering the first and last
+	// lines on the screen, which together describe the visible region.
+	Top, Bottom       float64
+	TopRow, BottomRow int
+}
+
+// Statistics of a line for the minimap
+type lineStat struct {
+	chars, ink int // Characters, and characters that are not whitespace
+	kinds      []kindCount
+}
+
+// The number of non-whitespace characters in tokens of a kind
+type kindCount struct {
+	kind string
+	ink  int
+}
+
+// Minimap returns a summary of the buffer in the given number of rows, or in
+// one row per line if the buffer has fewer lines. The lines are divided among
+// the rows as evenly as possible, and include lines hidden by folds. The style
+// of a row is the kind of the tokens of the buffer's tokenizer that cover the
+// most non-whitespace characters in its lines, or empty if no tokenizer is set
+// or no such tokens are in the lines.
+//
+// The statistics of each line are cached until the line is edited or relexed,
+// so calling Minimap after every edit is much cheaper than reading the buffer.
+func (t *TkText) Minimap(rows int) Minimap {
+	t.mutex.Lock()
+	defer t.unlock()
+	stats := t.lineStats()
+	n := len(stats)
+	if rows > n {
+		rows = n
+	}
+	if rows < 1 {
+		rows = 1
+	}
+
+	var m Minimap
+	m.Rows = make([]MinimapRow, rows)
+	for i := range m.Rows {
+		first, last := i*n/rows+1, (i+1)*n/rows
+		var chars, ink int
+		var kinds []kindCount
+		for _, st := range stats[first-1 : last] {
+			chars, ink = chars+st.chars, ink+st.ink
+			if t.tokenizer != nil {
+				kinds = addKinds(kinds, st.kinds)
+			}
+		}
+		row := MinimapRow{First: first, Last: last}
+		if chars > 0 {
+			row.Density = float64(ink) / float64(chars)
+		}
+		best := 0
+		for _, k := range kinds {
+			if k.ink > best || k.ink == best && best > 0 && k.kind < row.Style {
+				row.Style, best = k.kind, k.ink
+			}
+		}
+		m.Rows[i] = row
+	}
+
+	m.Top, m.Bottom = t.yview()
+	if screen := t.screenLines(); len(screen) > 0 {
+		m.TopRow = m.rowOf(screen[0].line)
+		m.BottomRow = m.rowOf(screen[len(screen)-1].line)
+	}
+	return m
+}
+
+// Return the index of the row covering line n
+func (m Minimap) rowOf(n int) int {
+	i := sort.Search(len(m.Rows), func(i int) bool {
+		return m.Rows[i].Last >= n
+	})
+	if i == len(m.Rows) {
+		i--
+	}
+	return i
+}
+
+// Return the statistics of every line, computing and caching those that are
+// not cached
+func (t *TkText) lineStats() []*lineStat {
+	stats := make([]*lineStat, 0, t.lines.Len())
+	var entries []lineEntry
+	first := 0 // First line of the entries to cache
+	flush := func() {
+		if len(entries) > 0 {
+			t.lines = t.lines.replace(first-1, first-1+len(entries), entries)
+			entries = nil
+		}
+	}
+	t.lines.eachEntry(1, func(n int, e lineEntry) bool {
+		if e.stat == nil {
+			if len(entries) == 0 {
+				first = n
+			}
+			e.stat = t.measureLine(n, e.s)
+			entries = append(entries, e)
+		} else {
+			flush()
+		}
+		stats = append(stats, e.stat)
+		return true
+	})
+	flush()
+	return stats
+}
+
+// Compute the statistics of line n, whose contents are s
+func (t *TkText) measureLine(n int, s string) *lineStat {
+	st := &lineStat{}
+	for _, ch := range s {
+		st.chars++
+		if !unicode.IsSpace(ch) {
+			st.ink++
+		}
+	}
+	for _, tok := range t.lineTokens(n) {
+		if tok.Kind == "" {
+			continue
+		}
+		ink := 0
+		for _, ch := range s[tok.Start:tok.End] {
+			if !unicode.IsSpace(ch) {
+				ink++
+			}
+		}
+		st.kinds = addKinds(st.kinds, []kindCount{{tok.Kind, ink}})
+	}
+	return st
+}
+
+// Add the counts of b to those of the same kinds in a
+func addKinds(a, b []kindCount) []kindCount {
+outer:
+	for _, kb := range b {
+		for i := range a {
+			if a[i].kind == kb.kind {
+				a[i].ink += kb.ink
+				continue outer
+			}
+		}
+		a = append(a, kb)
+	}
+	return a
+}
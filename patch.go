@@ -0,0 +1,266 @@
+package tktext
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderRegexp = regexp.MustCompile(
+	`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// PatchHunk is a hunk of a unified diff. Lines are counted from one, and a
+// hunk that only adds lines gives the line after which they are added.
+type PatchHunk struct {
+	OldLine, OldCount int
+	NewLine, NewCount int
+
+	// Lines are the lines of the hunk without their line breaks, each
+	// starting with ' ' for context, '-' for a deleted line, '+' for an
+	// added line, or '\' if the line before has no line break.
+	Lines []string
+}
+
+// PatchOptions configures ApplyPatch.
+type PatchOptions struct {
+	// Fuzz is the number of lines of context at the start and end of a hunk
+	// that may be ignored if the hunk does not otherwise match, like the
+	// -F option of patch.
+	Fuzz int
+
+	// MaxOffset is the number of lines by which a hunk may be moved from
+	// the line given by its header, after accounting for the lines added
+	// and deleted by the hunks before it. The nearest position is used.
+	MaxOffset int
+}
+
+// AppliedHunk describes where a hunk of a patch was applied.
+type AppliedHunk struct {
+	PatchHunk
+	Line   int // Line of the buffer at which the hunk's first line matched
+	Offset int // Lines between Line and the line given by the header
+	Fuzz   int // Lines of context that were ignored
+}
+
+// PatchResult reports which hunks of a patch were applied.
+type PatchResult struct {
+	Applied []AppliedHunk
+	Failed  []PatchHunk
+}
+
+// A line of a hunk, with its line break if it has one
+type patchLine struct {
+	op   byte
+	text string
+}
+
+// ParsePatch parses the hunks of a unified diff of one file, such as the
+// output of diff -u or git diff. Lines before the first hunk, such as file
+// headers, are ignored. Returns an error if a hunk is malformed, or if the
+// diff has headers for more than one file.
+func ParsePatch(patch string) ([]PatchHunk, error) {
+	var hunks []PatchHunk
+	lines := strings.Split(strings.TrimSuffix(patch, "\n"), "\n")
+	files := 0
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "+++ ") {
+			if files++; files > 1 {
+				return nil, fmt.Errorf("line %d: patch of more than one file",
+					i+1)
+			}
+		}
+		match := hunkHeaderRegexp.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		h := PatchHunk{atoi(match[1], 0), atoi(match[2], 1), atoi(match[3], 0),
+			atoi(match[4], 1), nil}
+
+		// Read lines until the counts in the header are reached
+		deleted, added := 0, 0 // Lines of the old and new text
+		for deleted < h.OldCount || added < h.NewCount {
+			if i++; i == len(lines) {
+				return nil, fmt.Errorf("line %d: hunk ends early", i)
+			}
+			line := lines[i]
+			if line == "" {
+				line = " " // Context whose trailing space was removed
+			}
+			switch line[0] {
+			case ' ':
+				deleted, added = deleted+1, added+1
+			case '-':
+				deleted++
+			case '+':
+				added++
+			case '\\':
+			default:
+				return nil, fmt.Errorf("line %d: bad hunk line: %s", i+1, line)
+			}
+			h.Lines = append(h.Lines, line)
+		}
+		if deleted > h.OldCount || added > h.NewCount {
+			return nil, fmt.Errorf("line %d: hunk is longer than its header",
+				i+1)
+		}
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+			i++
+			h.Lines = append(h.Lines, lines[i])
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks, nil
+}
+
+// Parse a decimal number, or return def if s is empty
+func atoi(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Return the lines of the hunk with their line breaks
+func (h PatchHunk) patchLines() []patchLine {
+	var lines []patchLine
+	for _, s := range h.Lines {
+		if s[0] == '\\' {
+			if n := len(lines); n > 0 {
+				lines[n-1].text = strings.TrimSuffix(lines[n-1].text, "\n")
+			}
+			continue
+		}
+		lines = append(lines, patchLine{s[0], s[1:] + "\n"})
+	}
+	return lines
+}
+
+// ApplyPatch applies the hunks of a unified diff, as parsed by ParsePatch, to
+// the buffer. Each hunk is applied where the lines it deletes and its context
+// match the buffer, searching outward from the line given by its header as
+// far as opts.MaxOffset lines, first with all of its context and then
+// ignoring up to opts.Fuzz lines of context at each end. Hunks are applied in
+// order, and a hunk may not overlap the hunk before it. Hunks that do not
+// match are not applied, and are reported in the result.
+//
+// The hunks are applied by inserting and deleting only the changed lines, so
+// marks elsewhere in the buffer keep their places, and the changes form a
+// single undoable change. An error is returned only if the patch cannot be
+// parsed, in which case the buffer is not changed.
+func (t *TkText) ApplyPatch(patch string, opts PatchOptions) (PatchResult,
+	error) {
+	hunks, err := ParsePatch(patch)
+	if err != nil {
+		return PatchResult{}, err
+	}
+	var result PatchResult
+	t.Transaction(func(tx *Tx) error {
+		delta, free := 0, 1 // Net lines added, and first line free to patch
+		for _, h := range hunks {
+			lines := h.patchLines()
+			start := h.OldLine + delta
+			if h.OldCount == 0 {
+				start++
+			}
+			line, fuzz, lead, ok := tx.t.findHunk(lines, start, free, opts)
+			if !ok {
+				result.Failed = append(result.Failed, h)
+				continue
+			}
+			result.Applied = append(result.Applied,
+				AppliedHunk{h, line - lead, line - lead - start, fuzz})
+			free = tx.applyHunk(lines[lead:], line)
+			delta += h.NewCount - h.OldCount
+		}
+		return nil
+	})
+	return result, nil
+}
+
+// Find where the hunk's lines match the buffer, no earlier than line first,
+// and return the line where the matched lines start, the fuzz used, and the
+// number of leading lines of context that were ignored
+func (t *TkText) findHunk(lines []patchLine, start, first int,
+	opts PatchOptions) (line, fuzz, lead int, ok bool) {
+	for fuzz = 0; fuzz <= opts.Fuzz; fuzz++ {
+		lead, trail := 0, 0
+		for lead < fuzz && lead < len(lines) && lines[lead].op == ' ' {
+			lead++
+		}
+		for trail < fuzz && trail < len(lines)-lead &&
+			lines[len(lines)-1-trail].op == ' ' {
+			trail++
+		}
+		if fuzz > 0 && lead+trail == 0 {
+			break // Ignoring more context would not change anything
+		}
+		trimmed := lines[lead : len(lines)-trail]
+		for off := 0; off <= opts.MaxOffset; off++ {
+			for _, line := range []int{start + lead - off, start + lead + off} {
+				if line >= first && line <= t.lines.Len() &&
+					t.matchHunk(trimmed, line) {
+					return line, fuzz, lead, true
+				}
+				if off == 0 {
+					break
+				}
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// Report whether the context and deleted lines of a hunk match the buffer
+// from line n onward
+func (t *TkText) matchHunk(lines []patchLine, n int) bool {
+	for _, l := range lines {
+		if l.op == '+' {
+			continue
+		}
+		if n > t.lines.Len() {
+			return false
+		}
+		s := t.getLine(n)
+		if n < t.lines.Len() {
+			s += "\n"
+		}
+		if s != l.text {
+			return false
+		}
+		n++
+	}
+	return true
+}
+
+// Apply the lines of a hunk to the buffer from line n onward, replacing each
+// run of deleted and added lines, and return the line after the hunk
+func (tx *Tx) applyHunk(lines []patchLine, n int) int {
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			n, i = n+1, i+1
+			continue
+		}
+		deleted, added := "", ""
+		count := 0
+		for ; i < len(lines) && lines[i].op == '-'; i++ {
+			deleted += lines[i].text
+			count++
+		}
+		for ; i < len(lines) && lines[i].op == '+'; i++ {
+			added += lines[i].text
+		}
+		start := Position{n, 0}
+		if count > 0 {
+			end := Position{n + count, 0}
+			if !strings.HasSuffix(deleted, "\n") {
+				end = Position{n + count - 1, len(tx.t.getLine(n + count - 1))}
+			}
+			tx.Delete(start.String(), end.String())
+		}
+		tx.Insert(start.String(), added)
+		n += strings.Count(added, "\n")
+	}
+	return n
+}
@@ -0,0 +1,163 @@
+package tktext
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Renderer draws the view of a TkText buffer to a terminal using ANSI escape
+// sequences. It remembers what it last drew, and only redraws the cells that
+// have changed since. A Renderer is also a Screen, so it can be passed to
+// Draw with a View.
+type Renderer struct {
+	// Styles maps the kinds of the buffer's tokens to the styles in which
+	// they are drawn by Render.
+	Styles map[string]Style
+
+	// Selection is the style in which selected text is drawn by Render. It
+	// is applied on top of token styles, as described for View.
+	Selection Style
+
+	// GutterStyle is the style in which Render draws the buffer's gutter,
+	// if one is set with SetGutter.
+	GutterStyle Style
+
+	w             io.Writer
+	width, height int
+	back, front   [][]Cell
+	x, y          int   // Cursor position, or negative if hidden
+	style         Style // Current style of the terminal
+	cursor        bool  // Whether the terminal's cursor is shown
+}
+
+// NewRenderer returns a Renderer that writes to w, which is assumed to be a
+// terminal whose contents are unknown. The renderer has no size until it is
+// set by SetSize or by Render.
+func NewRenderer(w io.Writer) *Renderer {
+	return &Renderer{w: w, x: -1, y: -1}
+}
+
+// Render draws the text display of the buffer, as positioned by SetSize and
+// the view functions, to the terminal, and places the terminal's cursor at the
+// buffer's insert mark if it is visible. The renderer is resized to the text
+// display and its gutter if their size has changed.
+func (r *Renderer) Render(t *TkText) error {
+	view := &View{t, r.Styles, r.Selection, r.GutterStyle}
+	grid, x, y, show := view.paint(t.Snapshot())
+	width := 0
+	if len(grid) > 0 {
+		width = len(grid[0])
+	}
+	if width != r.width || len(grid) != r.height {
+		r.SetSize(width, len(grid))
+	}
+	return drawGrid(grid, x, y, show, r)
+}
+
+// SetSize changes the size of the renderer, as when the terminal is resized.
+// The next flush redraws the whole terminal.
+func (r *Renderer) SetSize(width, height int) {
+	r.width, r.height = width, height
+	r.back = newGrid(width, height)
+	r.front = nil
+}
+
+// Invalidate makes the next flush redraw the whole terminal.
+func (r *Renderer) Invalidate() {
+	r.front = nil
+}
+
+// SetCell sets the cell at column x and row y.
+func (r *Renderer) SetCell(x, y int, c Cell) {
+	if x >= 0 && x < r.width && y >= 0 && y < r.height {
+		r.back[y][x] = c
+	}
+}
+
+// ShowCursor places the cursor at column x and row y, or hides it if x or y
+// is negative.
+func (r *Renderer) ShowCursor(x, y int) {
+	r.x, r.y = x, y
+}
+
+// Size returns the width and height of the renderer in cells.
+func (r *Renderer) Size() (width, height int) {
+	return r.width, r.height
+}
+
+// Flush writes the cells that differ from those last written to the terminal,
+// and places the cursor.
+func (r *Renderer) Flush() error {
+	var b bytes.Buffer
+	if r.front == nil {
+		b.WriteString("\x1b[0m\x1b[H\x1b[2J")
+		r.style = Style{}
+		r.front = newGrid(r.width, r.height)
+	}
+
+	if r.cursor {
+		b.WriteString("\x1b[?25l")
+	}
+	for i, row := range r.back {
+		next := -1 // Column at which the terminal's cursor is, if known
+		for j := 0; j < len(row); j++ {
+			c, w := row[j], maxInt(row[j].Width, 1)
+			if j+w > len(row) || c.Width == 0 {
+				c, w = Cell{" ", 1, c.Style}, 1 // Not a whole grapheme
+			}
+			if cellsEqual(row[j:j+w], r.front[i][j:j+w]) {
+				continue
+			}
+			if j != next {
+				fmt.Fprintf(&b, "\x1b[%d;%dH", i+1, j+1)
+			}
+			if c.Style != r.style {
+				b.WriteString(sgr(c.Style))
+				r.style = c.Style
+			}
+			b.WriteString(c.Text)
+			copy(r.front[i][j:j+w], row[j:j+w])
+			next = j + w
+			j += w - 1
+		}
+	}
+	r.cursor = r.x >= 0 && r.y >= 0
+	if r.cursor {
+		fmt.Fprintf(&b, "\x1b[%d;%dH\x1b[?25h", r.y+1, r.x+1)
+	}
+
+	_, err := r.w.Write(b.Bytes())
+	return err
+}
+
+// Report whether two rows of cells are the same
+func cellsEqual(a, b []Cell) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the escape sequence that sets the terminal's attributes to a style
+func sgr(s Style) string {
+	b := []byte("\x1b[0")
+	if s.Bold {
+		b = append(b, ";1"...)
+	}
+	if s.Underline {
+		b = append(b, ";4"...)
+	}
+	if s.Reverse {
+		b = append(b, ";7"...)
+	}
+	if s.Fg != 0 {
+		b = append(b, fmt.Sprintf(";38;5;%d", s.Fg-1)...)
+	}
+	if s.Bg != 0 {
+		b = append(b, fmt.Sprintf(";48;5;%d", s.Bg-1)...)
+	}
+	return string(append(b, 'm'))
+}
@@ -0,0 +1,315 @@
+package tktext
+
+import "strings"
+
+// Color is a terminal color. The zero value is the terminal's default color.
+type Color int
+
+// Palette returns the color with the given index in the terminal's 256-color
+// palette.
+func Palette(n int) Color {
+	return Color(n + 1)
+}
+
+// Style is a set of display attributes for text.
+type Style struct {
+	Fg, Bg                   Color
+	Bold, Underline, Reverse bool
+}
+
+// Cell is a character cell on a screen.
+type Cell struct {
+	// Text is the grapheme drawn in the cell: a rune followed by any
+	// zero-width runes, such as combining marks, that the buffer's Measurer
+	// places on it.
+	Text string
+
+	// Width is the number of columns the grapheme covers. The cells after a
+	// wide grapheme are continuation cells, with no text and a width of
+	// zero, which are covered by it.
+	Width int
+
+	Style Style
+}
+
+var blankCell = Cell{" ", 1, Style{}}
+
+// Screen is a grid of character cells with a cursor, such as a terminal or a
+// canvas, on which a View can be drawn. Cells are counted in columns and rows
+// from zero, at the top left of the screen.
+type Screen interface {
+	// SetCell sets the cell at column x and row y. Cells outside the screen
+	// are ignored.
+	SetCell(x, y int, c Cell)
+
+	// ShowCursor places the cursor at column x and row y, or hides it if x
+	// or y is negative.
+	ShowCursor(x, y int)
+
+	// Size returns the width and height of the screen in cells.
+	Size() (width, height int)
+
+	// Flush displays the cells and cursor as they have been set since the
+	// last flush.
+	Flush() error
+}
+
+// View describes how a TkText buffer is drawn on a Screen.
+type View struct {
+	Text *TkText
+
+	// Styles maps the kinds of the buffer's tokens to the styles in which
+	// they are drawn.
+	Styles map[string]Style
+
+	// Selection is the style in which selected text is drawn. It is applied
+	// on top of token styles: its colors replace those of the token where
+	// they are not the default, and its attributes are added to the token's.
+	Selection Style
+
+	// GutterStyle is the style in which the buffer's gutter is drawn, if
+	// one is set with SetGutter.
+	GutterStyle Style
+}
+
+// Draw draws the text display of the view's buffer, as positioned by SetSize
+// and the view functions, at the top left of the screen, and places the
+// screen's cursor at the buffer's insert mark if it is visible. Each rune is
+// given as many cells as the buffer's Measurer gives it columns, so a
+// Measurer that measures in character cells, such as the default, should be
+// set. The rest of the screen is cleared, and the screen is flushed.
+func Draw(view *View, screen Screen) error {
+	grid, x, y, show := view.paint(view.Text.Snapshot())
+	return drawGrid(grid, x, y, show, screen)
+}
+
+// Copy a grid of cells and a cursor position to a screen, and flush it
+func drawGrid(grid [][]Cell, x, y int, show bool, screen Screen) error {
+	width, height := screen.Size()
+	for i := 0; i < height; i++ {
+		for j := 0; j < width; j++ {
+			c := blankCell
+			if i < len(grid) && j < len(grid[i]) {
+				c = grid[i][j]
+			}
+			if j+c.Width > width {
+				c = Cell{" ", 1, c.Style} // Wide grapheme cut off by the edge
+			}
+			screen.SetCell(j, i, c)
+		}
+	}
+	if show {
+		screen.ShowCursor(x, y)
+	} else {
+		screen.ShowCursor(-1, -1)
+	}
+	return screen.Flush()
+}
+
+// Draw the view of a snapshot into a grid of cells, and return the grid and
+// the cursor position, if visible
+func (v *View) paint(s *Snapshot) (grid [][]Cell, x, y int, show bool) {
+	t := s.t
+	gutter := t.gutterWidth()
+	grid = newGrid(gutter+t.width, t.height)
+
+	screen := t.screenLines()
+	styles := t.getScreenStyles()
+	selection := t.selRanges()
+	labels := t.getScreenGutter()
+	for i, sl := range screen {
+		// Draw each display line at the offset of its text, below any space
+		// above it
+		if sl.y < 0 || sl.y >= len(grid) {
+			continue
+		}
+		row := grid[sl.y]
+		if labels != nil {
+			j := 0
+			for _, ch := range labels[i] {
+				row[j] = Cell{string(ch), 1, v.GutterStyle}
+				j++
+			}
+		}
+
+		// Fill in the text and its token styles
+		cells := row[gutter:]
+		offsets := t.paintLine(cells, sl)
+		for _, run := range styles[i] {
+			for k, off := range offsets {
+				if run.Start <= off && off < run.End {
+					cells[k].Style = v.Styles[run.Style]
+				}
+			}
+		}
+
+		// Apply the selection style
+		for _, sel := range selection {
+			if sel.Start.Line > sl.line || sel.End.Line < sl.line {
+				continue
+			}
+			start, end := 0, len(t.getLine(sl.line))
+			if sel.Start.Line == sl.line {
+				start = sel.Start.Char
+			}
+			if sel.End.Line == sl.line {
+				end = sel.End.Char
+			}
+			start, end = t.screenSpan(sl, start, end)
+			for k, off := range offsets {
+				if start <= off && off < end {
+					cells[k].Style = overlay(cells[k].Style, v.Selection)
+				}
+			}
+		}
+	}
+
+	if m := t.marks[insertMark]; m != nil {
+		x, y = t.bbox(m.Position)
+		show = x >= 0 && x < t.width && y >= 0 && y < t.height
+		x += gutter
+	}
+	return
+}
+
+// Return a style with the colors and attributes of top applied on top of base
+func overlay(base, top Style) Style {
+	if top.Fg != 0 {
+		base.Fg = top.Fg
+	}
+	if top.Bg != 0 {
+		base.Bg = top.Bg
+	}
+	base.Bold = base.Bold || top.Bold
+	base.Underline = base.Underline || top.Underline
+	base.Reverse = base.Reverse || top.Reverse
+	return base
+}
+
+// Fill a row of cells with the text of a display line, giving each rune as
+// many cells as it is wide and adding zero-width runes to the cell before
+// them, and return the byte offset in the text drawn in each cell, or -1
+func (t *TkText) paintLine(cells []Cell, sl screenLine) []int {
+	offsets := make([]int, len(cells))
+	for k := range offsets {
+		offsets[k] = -1
+	}
+	col, lead := sl.x, -1 // Column of the next rune, and of the last drawn
+	for j, ch := range sl.text {
+		w := t.measurer.RuneWidth(ch)
+		if w <= 0 {
+			if lead >= 0 {
+				cells[lead].Text += string(ch)
+			}
+			continue
+		}
+		if col < 0 || col+w > len(cells) {
+			// Leave the visible part of a rune cut off by an edge blank
+			for k := maxInt(col, 0); k < col+w && k < len(cells); k++ {
+				offsets[k] = j
+			}
+			lead, col = -1, col+w
+			continue
+		}
+		cells[col] = Cell{string(ch), w, Style{}}
+		for k := col; k < col+w; k++ {
+			if k > col {
+				cells[k] = Cell{}
+			}
+			offsets[k] = j
+		}
+		lead, col = col, col+w
+	}
+	return offsets
+}
+
+// MemScreen is a Screen that keeps its cells in memory, for testing and for
+// frontends that draw from a grid of cells.
+type MemScreen struct {
+	back, front   [][]Cell
+	cursorX       int
+	cursorY       int
+	drawX, drawY  int
+	flushes       int
+	width, height int
+}
+
+// NewMemScreen returns a blank MemScreen of the given size, with the cursor
+// hidden.
+func NewMemScreen(width, height int) *MemScreen {
+	s := &MemScreen{width: width, height: height, cursorX: -1, cursorY: -1,
+		drawX: -1, drawY: -1}
+	s.back, s.front = newGrid(width, height), newGrid(width, height)
+	return s
+}
+
+// Return a grid of blank cells
+func newGrid(width, height int) [][]Cell {
+	grid := make([][]Cell, height)
+	for i := range grid {
+		grid[i] = make([]Cell, width)
+		for j := range grid[i] {
+			grid[i][j] = blankCell
+		}
+	}
+	return grid
+}
+
+// SetCell sets the cell at column x and row y.
+func (s *MemScreen) SetCell(x, y int, c Cell) {
+	if x >= 0 && x < s.width && y >= 0 && y < s.height {
+		s.back[y][x] = c
+	}
+}
+
+// ShowCursor places the cursor at column x and row y, or hides it if x or y
+// is negative.
+func (s *MemScreen) ShowCursor(x, y int) {
+	s.cursorX, s.cursorY = x, y
+}
+
+// Size returns the width and height of the screen in cells.
+func (s *MemScreen) Size() (width, height int) {
+	return s.width, s.height
+}
+
+// Flush displays the cells and cursor as they have been set since the last
+// flush.
+func (s *MemScreen) Flush() error {
+	for i := range s.back {
+		copy(s.front[i], s.back[i])
+	}
+	s.drawX, s.drawY = s.cursorX, s.cursorY
+	s.flushes++
+	return nil
+}
+
+// Cell returns the displayed cell at column x and row y.
+func (s *MemScreen) Cell(x, y int) Cell {
+	return s.front[y][x]
+}
+
+// Cursor returns the position of the displayed cursor, and whether it is
+// shown.
+func (s *MemScreen) Cursor() (x, y int, shown bool) {
+	return s.drawX, s.drawY, s.drawX >= 0 && s.drawY >= 0
+}
+
+// Flushes returns the number of times the screen has been flushed.
+func (s *MemScreen) Flushes() int {
+	return s.flushes
+}
+
+// String returns the displayed text of the screen, one line per row, with
+// trailing spaces removed.
+func (s *MemScreen) String() string {
+	rows := make([]string, len(s.front))
+	for i, row := range s.front {
+		var b strings.Builder
+		for _, c := range row {
+			b.WriteString(c.Text)
+		}
+		rows[i] = strings.TrimRight(b.String(), " ")
+	}
+	return strings.Join(rows, "\n")
+}
@@ -0,0 +1,200 @@
+package tktext
+
+// ScrollUnit determines the amount by which XViewScrollBy and YViewScrollBy
+// scroll the view, like the units of the Tk text widget's xview scroll and
+// yview scroll commands.
+type ScrollUnit uint8
+
+const (
+	Units  ScrollUnit = iota // Characters horizontally, display lines vertically
+	Pages                    // The size of the display, less two units
+	Pixels                   // Columns, lines, or units of the Measurer
+)
+
+// The view when a scan was started
+type scanState struct {
+	x, y    int // Coordinates passed to ScanMark
+	xScroll int
+	top     int // Offset of the top of the view from the top of the buffer
+}
+
+// SetSmoothScroll enables or disables smooth scrolling, which is disabled by
+// default. When smooth scrolling is disabled, the top of the view is always
+// the top of a display line. When it is enabled, scrolling by pixels, by
+// fractions, and by ScanDragTo can leave part of the top display line above
+// the top of the view, and YViewOffset reports how much.
+func (t *TkText) SetSmoothScroll(enabled bool) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.smooth = enabled
+	if !enabled {
+		t.yOffset = 0
+	}
+}
+
+// YViewOffset returns the height of the part of the top display line on the
+// screen that is scrolled above the top of the view. The first display line
+// returned by GetScreenLines should be drawn that far above the top of the
+// display. The offset is always zero unless smooth scrolling is enabled.
+func (t *TkText) YViewOffset() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.yOffset
+}
+
+// XViewScrollBy shifts the horizontal scrolling right by n of the given unit.
+// A unit is the width of the character "0".
+func (t *TkText) XViewScrollBy(n int, unit ScrollUnit) {
+	t.mutex.Lock()
+	defer t.unlock()
+	switch unit {
+	case Units:
+		n *= t.measurer.RuneWidth('0')
+	case Pages:
+		n *= maxInt(t.width-2*t.measurer.RuneWidth('0'), 1)
+	}
+	t.xviewScroll(n)
+}
+
+// YViewScrollBy shifts the vertical scrolling down by n of the given unit. A
+// unit is a display line. Unless smooth scrolling is enabled, scrolling by
+// pixels or pages scrolls by the display lines whose tops are passed.
+func (t *TkText) YViewScrollBy(n int, unit ScrollUnit) {
+	t.mutex.Lock()
+	defer t.unlock()
+	switch unit {
+	case Units:
+		t.yviewScroll(n)
+	case Pages:
+		h, _ := t.uniformRowHeight()
+		t.setViewTop(t.viewTop() + n*maxInt(t.height-2*h, h))
+	case Pixels:
+		t.setViewTop(t.viewTop() + n)
+	}
+}
+
+// ScanMark records the coordinates and the view for a following ScanDragTo,
+// like the Tk text widget's scan mark command. It is typically called when a
+// mouse button is pressed.
+func (t *TkText) ScanMark(x, y int) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.scan = scanState{x, y, t.xScroll, t.viewTop()}
+}
+
+// ScanDragTo scrolls the view by gain times the difference between the given
+// coordinates and those passed to the last ScanMark, relative to the view at
+// the time of that call, like the Tk text widget's scan dragto command. It is
+// typically called as the mouse moves. A gain of 1 keeps the text under the
+// mouse; Tk's default gain is 10.
+func (t *TkText) ScanDragTo(x, y, gain int) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.xScroll = t.scan.xScroll + (t.scan.x-x)*gain
+	t.scrollTo(t.scan.top + (t.scan.y-y)*gain)
+	t.clampView()
+}
+
+// Return the offset of the top of the view from the top of the buffer
+func (t *TkText) viewTop() int {
+	return t.rowTop(t.yScroll) + t.yOffset
+}
+
+// Scroll the top of the view to an offset from the top of the buffer, or to
+// the top of the display line at that offset if smooth scrolling is disabled
+func (t *TkText) scrollTo(y int) {
+	t.yScroll = t.rowAt(y)
+	t.yOffset = 0
+	if t.smooth {
+		t.yOffset = y - t.rowTop(t.yScroll)
+	}
+}
+
+// Like scrollTo, but keeps the view within the buffer
+func (t *TkText) setViewTop(y int) {
+	t.scrollTo(y)
+	t.clampView()
+}
+
+// SetScrollOff sets the minimum number of display lines that See keeps in view
+// above and below the display line of the index, like Vim's scrolloff option.
+// The margin is reduced where the display is too small for it, and at the top
+// and bottom of the buffer. The default is zero.
+func (t *TkText) SetScrollOff(lines int) {
+	t.mutex.Lock()
+	defer t.unlock()
+	if lines < 0 {
+		lines = 0
+	}
+	t.scrollOff = lines
+}
+
+// Keep the view within the buffer. Horizontal scrolling does not apply when
+// lines are wrapped.
+func (t *TkText) clampView() {
+	if t.wrapMode != None {
+		t.xScroll = 0
+	} else if t.xScroll > 0 {
+		if max := t.maxLine() - t.width; t.xScroll > max {
+			t.xScroll = max
+		}
+	}
+	if t.xScroll < 0 {
+		t.xScroll = 0
+	}
+	if t.smooth {
+		if max := t.rowTop(t.displayLines()) - t.height; t.viewTop() > max {
+			t.scrollTo(max)
+		}
+	} else if max := t.maxYScroll(); t.yScroll > max {
+		t.yScroll = max
+	}
+	if t.yScroll < 0 || t.yScroll == 0 && t.yOffset < 0 {
+		t.yScroll, t.yOffset = 0, 0
+	}
+}
+
+// Adjust the view so that pos is visible, along with the scrolloff margin
+func (t *TkText) see(pos Position) {
+	x, y, _, height, _ := t.dline(pos)
+	if t.wrapMode == None {
+		t.xScroll = seeSpan(t.xScroll, x, x+t.measurer.RuneWidth('0'), t.width)
+	}
+
+	top := t.viewTop()
+	y0, y1 := top+y, top+y+height
+	row, rows := t.rowAt(y0), t.displayLines()
+	for m := t.scrollOff; m > 0; m-- {
+		a, b := t.rowTop(maxInt(row-m, 0)), t.rowTop(minInt(row+m+1, rows))
+		if b-a <= t.height {
+			y0, y1 = minInt(a, y0), maxInt(b, y1)
+			break
+		}
+	}
+	if newTop := seeSpan(top, y0-top, y1-top, t.height); newTop != top {
+		t.scrollTo(newTop)
+		// Without smooth scrolling, the top of the view snaps up to the top
+		// of a display line, which can leave the span cut off at the bottom
+		if vt := t.viewTop(); y1 > vt+t.height && t.rowTop(t.yScroll+1) <= y0 {
+			t.yScroll, t.yOffset = t.yScroll+1, 0
+		}
+	}
+	t.clampView()
+}
+
+// Return the scroll offset of a view of the given size that brings the span
+// from start to end, relative to a view at offset, into view. Like Tk, the
+// view is moved just far enough if the span is out of view by less than a
+// third of its size, and centered on the span otherwise.
+func seeSpan(offset, start, end, size int) int {
+	near := size / 3
+	switch {
+	case start >= 0 && end <= size:
+		return offset
+	case end-start >= size, start < 0 && start >= -near:
+		return offset + start
+	case start >= 0 && end-size <= near:
+		return offset + end - size
+	}
+	return offset + (start+end)/2 - size/2
+}
@@ -0,0 +1,179 @@
+package tktext
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Granularity determines the unit by which a selection is extended.
+type Granularity uint8
+
+const (
+	SelectChar Granularity = iota // Selection ends fall at any character.
+	SelectWord                    // Selection is extended to whole words.
+	SelectLine                    // Selection is extended to whole lines.
+)
+
+// Names of the marks that bound the selection.
+const (
+	insertMark = "insert"
+	anchorMark = "anchor"
+)
+
+// Range denotes the text between two positions in a buffer.
+type Range struct {
+	Start, End Position
+}
+
+type rangeSort []Range
+
+func (a rangeSort) Len() int      { return len(a) }
+func (a rangeSort) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a rangeSort) Less(i, j int) bool {
+	return comparePos(a[i].Start, a[j].Start) < 0
+}
+
+// Return the range selected by a cursor, extended according to the selection
+// granularity, and whether the range is non-empty
+func (t *TkText) cursorRange(c cursor) (Range, bool) {
+	anchor, insert := t.marks[c.anchor], t.marks[c.insert]
+	if anchor == nil || insert == nil {
+		return Range{}, false
+	}
+	start, end := anchor.Position, insert.Position
+	if comparePos(start, end) > 0 {
+		start, end = end, start
+	}
+
+	switch t.selUnit {
+	case SelectWord:
+		collapsed := start == end
+		line := t.getLine(start.Line)
+		start.Char = t.runStart(line, start.Char)
+		if collapsed {
+			end.Char = t.wordEnd(line, end.Char)
+		} else if end.Char > 0 {
+			line = t.getLine(end.Line)
+			_, size := utf8.DecodeLastRuneInString(line[:end.Char])
+			end.Char = t.wordEnd(line, t.runStart(line, end.Char-size))
+		}
+	case SelectLine:
+		start.Char = 0
+		if end.Line < t.lines.Len() {
+			end = Position{end.Line + 1, 0}
+		} else {
+			end.Char = len(t.lines.last())
+		}
+	}
+
+	return Range{start, end}, comparePos(start, end) < 0
+}
+
+// Return the non-empty ranges selected by all cursors, in order and with
+// overlapping ranges merged
+func (t *TkText) selRanges() []Range {
+	var ranges []Range
+	for _, c := range t.cursorList() {
+		if r, ok := t.cursorRange(c); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Sort(rangeSort(ranges))
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && comparePos(r.Start, merged[n-1].End) <= 0 {
+			if comparePos(r.End, merged[n-1].End) > 0 {
+				merged[n-1].End = r.End
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// SelectionSet sets the selection to the text between the anchor and insert
+// indices, which may be given in either order. The ends of the selection are
+// tracked by marks named "anchor" and "insert", so they follow edits to the
+// buffer. The selection granularity is reset to SelectChar.
+func (t *TkText) SelectionSet(anchor, insert string) {
+	t.mutex.Lock()
+	defer t.unlock()
+	anchorPos, insertPos := t.index(anchor), t.index(insert)
+	t.setMark(anchorMark, anchorPos)
+	t.setMark(insertMark, insertPos)
+	t.selUnit = SelectChar
+}
+
+// SelectionExtend moves the insert end of the selection to the given index,
+// leaving the anchor in place, and sets the granularity by which the selected
+// range is extended. If no selection is set, the anchor is placed at the
+// current insert mark, or at the given index if that mark is not set either.
+func (t *TkText) SelectionExtend(index string, unit Granularity) {
+	t.mutex.Lock()
+	defer t.unlock()
+	pos := t.index(index)
+	if t.marks[anchorMark] == nil {
+		if insert := t.marks[insertMark]; insert != nil {
+			t.setMark(anchorMark, insert.Position)
+		} else {
+			t.setMark(anchorMark, pos)
+		}
+	}
+	t.setMark(insertMark, pos)
+	t.selUnit = unit
+}
+
+// SelectionClear clears the selection by removing the anchor mark. The insert
+// mark is left in place.
+func (t *TkText) SelectionClear() {
+	t.mutex.Lock()
+	defer t.unlock()
+	delete(t.marks, anchorMark)
+	t.selUnit = SelectChar
+}
+
+// SelectionRanges returns the ranges selected by all cursors in order, with
+// overlapping ranges merged. The result is empty if nothing is selected.
+func (t *TkText) SelectionRanges() []Range {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.selRanges()
+}
+
+// SelectionGet returns the selected text, or an empty string if nothing is
+// selected. If multiple ranges are selected, their text is joined by line
+// breaks.
+func (t *TkText) SelectionGet() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	ranges := t.selRanges()
+	texts := make([]string, len(ranges))
+	for i, r := range ranges {
+		texts[i] = t.get(r.Start, r.End)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// SelectionDelete deletes the selected text and collapses the selection to
+// the position of the deletion. If multiple ranges are selected, they are all
+// deleted as a single undoable change. Returns true if and only if text was
+// deleted.
+func (t *TkText) SelectionDelete() bool {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	ranges := t.selRanges()
+	t.selUnit = SelectChar
+	if len(ranges) > 0 {
+		t.separate()
+		for i := len(ranges) - 1; i >= 0; i-- {
+			t.del(ranges[i].Start, ranges[i].End, true)
+		}
+		t.separate()
+		t.mergeCursors()
+	}
+	return len(ranges) > 0
+}
@@ -0,0 +1,173 @@
+package tktext
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// Snapshot is an immutable, read-only view of a TkText buffer as it was at the
+// time the snapshot was taken, including its marks and display settings. Later
+// changes to the buffer do not affect the snapshot. A Snapshot takes no locks,
+// so long-running readers on other goroutines can use one without blocking
+// edits to the buffer.
+type Snapshot struct {
+	t *TkText
+}
+
+// Snapshot returns a snapshot of the buffer. Taking a snapshot copies the
+// marks and settings of the buffer, but shares its contents, so it is cheap
+// even for large buffers.
+func (t *TkText) Snapshot() *Snapshot {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	frozen := *t
+	frozen.mutex = &sync.RWMutex{}
+	frozen.undoStack, frozen.redoStack = list.New(), list.New()
+	frozen.marks = make(map[string]*mark, len(t.marks))
+	for k, v := range t.marks {
+		m := *v
+		frozen.marks[k] = &m
+	}
+	frozen.cursors = append([]cursor(nil), t.cursors...)
+	frozen.folds = append([]fold(nil), t.folds...)
+	frozen.spacings = append([]spacingRange(nil), t.spacings...)
+	frozen.handlers = nil
+	return &Snapshot{&frozen}
+}
+
+// BBox returns the row and column numbers of the given index on the screen, as
+// described for TkText.BBox.
+func (s *Snapshot) BBox(index string) (x, y int) {
+	x, y = s.t.bbox(s.t.index(index))
+	return x + s.t.gutterWidth(), y
+}
+
+// Compare returns a positive integer if index1 is greater than index2, a
+// negative integer if index1 is less than index2, and zero if the indices are
+// equal.
+func (s *Snapshot) Compare(index1, index2 string) int {
+	return comparePos(s.t.index(index1), s.t.index(index2))
+}
+
+// CountChars returns the number of UTF-8 characters between two indices. If
+// index1 is after index2, the result will be a negative number.
+func (s *Snapshot) CountChars(index1, index2 string) int {
+	return s.t.countChars(s.t.index(index1), s.t.index(index2))
+}
+
+// CountLines returns the number of line breaks between two indices. If index1
+// is after index2, the result will be a negative number (or zero).
+func (s *Snapshot) CountLines(index1, index2 string) int {
+	return s.t.index(index2).Line - s.t.index(index1).Line
+}
+
+// CountDisplayLines returns the number of displayed line breaks between two
+// indices, taking wrapping into account. If index1 is after index2, the result
+// will be a negative number (or zero).
+func (s *Snapshot) CountDisplayLines(index1, index2 string) int {
+	return s.t.countDisplayLines(s.t.index(index1), s.t.index(index2))
+}
+
+// CountXPixels returns the horizontal distance from index1 to index2 on the
+// display, as described for TkText.CountXPixels.
+func (s *Snapshot) CountXPixels(index1, index2 string) int {
+	return s.t.countXPixels(s.t.index(index1), s.t.index(index2))
+}
+
+// CountYPixels returns the vertical distance from the display line of index1
+// to that of index2, as described for TkText.CountYPixels.
+func (s *Snapshot) CountYPixels(index1, index2 string) int {
+	return s.t.countYPixels(s.t.index(index1), s.t.index(index2))
+}
+
+// DLineInfo the starting row and column numbers of the display line containing
+// the given index, as well as the width of that line in columns.
+func (s *Snapshot) DLineInfo(index string) (x, y, width int) {
+	x, y, width = s.t.dlineInfo(s.t.index(index))
+	return x + s.t.gutterWidth(), y, width
+}
+
+// Folds returns the folds that were in the snapshot's buffer, as described for
+// TkText.Folds.
+func (s *Snapshot) Folds() []Fold {
+	return s.t.foldList()
+}
+
+// FoldRegions returns the foldable regions that were computed for the
+// snapshot's buffer, as described for TkText.FoldRegions.
+func (s *Snapshot) FoldRegions() []Range {
+	return s.t.foldRegions()
+}
+
+// Get returns the text between two indices as a string. If index1 is after
+// index2, an empty string will be returned.
+func (s *Snapshot) Get(index1, index2 string) string {
+	return s.t.get(s.t.index(index1), s.t.index(index2))
+}
+
+// GetScreenLines returns a slice of strings, one for each display line on the
+// screen.
+func (s *Snapshot) GetScreenLines() []string {
+	return s.t.getScreenLines()
+}
+
+// GetScreenGutter returns the gutter of each display line on the screen, as
+// described for TkText.GetScreenGutter.
+func (s *Snapshot) GetScreenGutter() []string {
+	return s.t.getScreenGutter()
+}
+
+// GetScreenStyles returns a slice of style runs for each display line on the
+// screen, as described for TkText.GetScreenStyles.
+func (s *Snapshot) GetScreenStyles() [][]StyleRun {
+	return s.t.getScreenStyles()
+}
+
+// GutterWidth returns the width of the gutter in columns, or zero if no gutter
+// was shown.
+func (s *Snapshot) GutterWidth() int {
+	return s.t.gutterWidth()
+}
+
+// Index parses a string index and returns an equivalent valid Position in the
+// snapshot.
+func (s *Snapshot) Index(index string) Position {
+	return s.t.index(index)
+}
+
+// MarkNames returns a slice of names of marks that were set when the snapshot
+// was taken.
+func (s *Snapshot) MarkNames() []string {
+	return s.t.markNames()
+}
+
+// SelectionRanges returns the ranges that were selected when the snapshot was
+// taken.
+func (s *Snapshot) SelectionRanges() []Range {
+	return s.t.selRanges()
+}
+
+// SelectionGet returns the text that was selected when the snapshot was
+// taken, joining multiple ranges with newlines.
+func (s *Snapshot) SelectionGet() string {
+	ranges := s.t.selRanges()
+	texts := make([]string, len(ranges))
+	for i, r := range ranges {
+		texts[i] = s.t.get(r.Start, r.End)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// XView returns the horizontal view fractions of the snapshot, as described
+// for TkText.XView.
+func (s *Snapshot) XView() (left, right float64) {
+	return s.t.xview()
+}
+
+// YView returns the vertical view fractions of the snapshot, as described for
+// TkText.YView.
+func (s *Snapshot) YView() (top, bottom float64) {
+	return s.t.yview()
+}
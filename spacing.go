@@ -0,0 +1,184 @@
+package tktext
+
+// LineSpacing describes the vertical geometry of the display lines of a line
+// in the buffer, like the Tk text widget's -spacing1, -spacing2, and -spacing3
+// options. Heights are in lines, or in the units of the buffer's Measurer if
+// one is set.
+type LineSpacing struct {
+	Height  int // Height of the text of a display line, or zero for default
+	Above   int // Space above the first display line (-spacing1)
+	Between int // Space above each following display line (-spacing2)
+	Below   int // Space below the last display line (-spacing3)
+}
+
+type spacingRange struct {
+	id         int
+	start, end mark
+	spacing    LineSpacing
+}
+
+// SetLineSpacing sets the spacing of lines that are not in a range added by
+// LineSpacingAdd. A zero Height is the line height of the buffer's Measurer,
+// which is one line by default.
+func (t *TkText) SetLineSpacing(s LineSpacing) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.spacing = s
+	t.damageAll()
+	t.clampView()
+}
+
+// LineSpacingAdd sets the spacing of the lines from that of index1 to that of
+// index2, and returns the ID of the range of lines, which can be passed to
+// LineSpacingRemove. The bounds of the range are adjusted as the text is
+// edited. Where ranges overlap, the most recently added range applies.
+func (t *TkText) LineSpacingAdd(index1, index2 string, s LineSpacing) int {
+	t.mutex.Lock()
+	defer t.unlock()
+	pos1, pos2 := t.index(index1), t.index(index2)
+	if pos1.Line > pos2.Line {
+		pos1, pos2 = pos2, pos1
+	}
+	t.spacingID++
+	t.spacings = append(t.spacings, spacingRange{
+		t.spacingID,
+		mark{Position{pos1.Line, 0}, Right, ""},
+		mark{Position{pos2.Line, len(t.getLine(pos2.Line))}, Left, ""},
+		s,
+	})
+	t.damageAll()
+	t.clampView()
+	return t.spacingID
+}
+
+// LineSpacingRemove removes the ranges of line spacing with the given IDs, if
+// they exist.
+func (t *TkText) LineSpacingRemove(id ...int) {
+	t.mutex.Lock()
+	defer t.unlock()
+	for _, v := range id {
+		for i, r := range t.spacings {
+			if r.id == v {
+				t.spacings = append(t.spacings[:i:i], t.spacings[i+1:]...)
+				break
+			}
+		}
+	}
+	t.damageAll()
+	t.clampView()
+}
+
+// Return the spacing of line n, with its height resolved
+func (t *TkText) lineSpacing(n int) LineSpacing {
+	s := t.spacing
+	for i := len(t.spacings) - 1; i >= 0; i-- {
+		if r := t.spacings[i]; r.start.Line <= n && n <= r.end.Line {
+			s = r.spacing
+			break
+		}
+	}
+	if s.Height == 0 {
+		s.Height = t.measurer.LineHeight()
+	}
+	return s
+}
+
+// Return the height of display line r of a line with the given spacing and
+// number of display lines, and the offset of its text below its top
+func rowHeight(s LineSpacing, r, rows int) (height, offset int) {
+	if r == 0 {
+		offset = s.Above
+	} else {
+		offset = s.Between
+	}
+	height = offset + s.Height
+	if r == rows-1 {
+		height += s.Below
+	}
+	return
+}
+
+// Return the height of every display line, if they are all the same height
+func (t *TkText) uniformRowHeight() (int, bool) {
+	s := t.lineSpacing(0)
+	uniform := len(t.spacings) == 0 && (t.wrapMode == None ||
+		t.width <= 0 || s.Above == 0 && s.Between == 0 && s.Below == 0)
+	return s.Height + s.Above + s.Below, uniform
+}
+
+// Call f with the height of each display line in the buffer, counting from
+// zero, until it returns false. Returns the number of display lines visited
+// and their total height.
+func (t *TkText) eachRow(f func(row, height int) bool) (n, y int) {
+	t.lines.each(1, func(line int, s string) bool {
+		if t.lineHidden(line) {
+			return true
+		}
+		sp := t.lineSpacing(line)
+		rows := t.displayRows(line, s, len(s))
+		for r := 0; r < rows; r++ {
+			h, _ := rowHeight(sp, r, rows)
+			if !f(n, h) {
+				return false
+			}
+			n, y = n+1, y+h
+		}
+		return true
+	})
+	return
+}
+
+// Return the offset of the top of a display line from the top of the buffer.
+// Display lines past the end of the buffer have the default height.
+func (t *TkText) rowTop(row int) int {
+	h, uniform := t.uniformRowHeight()
+	if uniform || row <= 0 {
+		return row * h
+	}
+	n, y := t.eachRow(func(n, _ int) bool {
+		return n < row
+	})
+	return y + (row-n)*h
+}
+
+// Return the display line at an offset from the top of the buffer
+func (t *TkText) rowAt(y int) int {
+	h, uniform := t.uniformRowHeight()
+	if uniform || y <= 0 {
+		if h <= 0 {
+			return 0
+		}
+		return y / h
+	}
+	rest := y       // Offset of y below the top of the row being visited
+	inside := false // Whether the walk stopped at the row containing y
+	n, _ := t.eachRow(func(_, height int) bool {
+		if inside = rest < height; !inside {
+			rest -= height
+		}
+		return !inside
+	})
+	if inside || h <= 0 {
+		return n
+	}
+	return n + rest/h
+}
+
+// Return the greatest vertical scroll at which the display is filled
+func (t *TkText) maxYScroll() int {
+	bottom := t.rowTop(t.displayLines()) - t.height
+	row := t.rowAt(bottom)
+	if t.rowTop(row) < bottom {
+		row++
+	}
+	return row
+}
+
+// DLineHeight returns the height of the display line containing the given
+// index, including the spacing above and below it.
+func (t *TkText) DLineHeight(index string) int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	_, _, _, height, _ := t.dline(t.index(index))
+	return height
+}
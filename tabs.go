@@ -1,46 +1,257 @@
 package tktext
 
-import "sync"
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
-var p []rune                // Reuse the same array for efficiency's sake
-var mutex = &sync.RWMutex{} // But that means we have to put a mutex on it
+// TabAlign determines how the text following a tab is aligned to the tab's
+// tab stop. The text aligned is the text up to the next tab or the end of the
+// line.
+type TabAlign uint8
 
-// Expand tabs to spaces
-func expand(s string, tabStop int) string {
-	mutex.Lock()
-	if len(p) < tabStop*len(s) {
-		p = make([]rune, tabStop*len(s))
+const (
+	AlignLeft    TabAlign = iota // Text starts at the tab stop.
+	AlignRight                   // Text ends at the tab stop.
+	AlignCenter                  // Text is centered on the tab stop.
+	AlignNumeric                 // First decimal point is at the tab stop.
+)
+
+var tabAlignNames = map[string]TabAlign{
+	"left":    AlignLeft,
+	"right":   AlignRight,
+	"center":  AlignCenter,
+	"numeric": AlignNumeric,
+}
+
+// TabStop is a tab position, in columns from the start of a line, and the
+// alignment of text at that position.
+type TabStop struct {
+	Column int
+	Align  TabAlign
+}
+
+// TabStyle determines which tab stop is used by each tab in a line.
+type TabStyle uint8
+
+const (
+	// A tab uses the first tab stop to the right of the preceding text.
+	WordProcessor TabStyle = iota
+	// The nth tab in a line uses the nth tab stop. If the preceding text
+	// extends past the tab stop, the tab is one column wide.
+	Tabular
+)
+
+type tabConfig struct {
+	width int
+	stops []TabStop
+	style TabStyle
+}
+
+// ParseTabs parses a list of tab stops in the format of the Tk text widget's
+// -tabs option: a whitespace-separated list of columns, each optionally
+// followed by an alignment of "left", "right", "center", or "numeric".
+// Alignments may be abbreviated, and default to left.
+func ParseTabs(spec string) ([]TabStop, error) {
+	var stops []TabStop
+	for _, field := range strings.Fields(spec) {
+		if col, err := strconv.Atoi(field); err == nil {
+			stops = append(stops, TabStop{col, AlignLeft})
+			continue
+		}
+		if len(stops) == 0 {
+			return nil, errors.New("tab alignment without a tab stop: " + field)
+		}
+		align, ok := TabAlign(0), false
+		for name, v := range tabAlignNames {
+			if strings.HasPrefix(name, field) {
+				align, ok = v, true
+			}
+		}
+		if !ok {
+			return nil, errors.New("bad tab alignment: " + field)
+		}
+		stops[len(stops)-1].Align = align
+	}
+	return stops, checkTabs(stops)
+}
+
+// Return an error if the tab stops are not positive and strictly increasing
+func checkTabs(stops []TabStop) error {
+	prev := 0
+	for _, stop := range stops {
+		if stop.Column <= prev {
+			return errors.New("tab stops must be positive and increasing")
+		}
+		prev = stop.Column
+	}
+	return nil
+}
+
+// Return tab stop n, counting from zero. Stops beyond the end of the list
+// repeat the interval and alignment of the last stop.
+func (c tabConfig) stop(n int) TabStop {
+	if len(c.stops) == 0 {
+		return TabStop{(n + 1) * c.width, AlignLeft}
+	}
+	if n < len(c.stops) {
+		return c.stops[n]
 	}
+	last := c.stops[len(c.stops)-1]
+	return TabStop{last.Column + (n-len(c.stops)+1)*c.interval(), last.Align}
+}
+
+// Return the interval between extrapolated tab stops
+func (c tabConfig) interval() int {
+	n := len(c.stops)
+	if n == 1 {
+		return c.stops[0].Column
+	}
+	return c.stops[n-1].Column - c.stops[n-2].Column
+}
+
+// Return the index of the first tab stop to the right of the given column
+func (c tabConfig) next(col int) int {
+	if len(c.stops) == 0 {
+		return col / c.width
+	}
+	for n, stop := range c.stops {
+		if stop.Column > col {
+			return n
+		}
+	}
+	last := c.stops[len(c.stops)-1]
+	return len(c.stops) + (col-last.Column)/c.interval()
+}
+
+// Return the widths in columns of the tabs in a line, in order
+func (c tabConfig) widths(s string) []int {
+	if strings.IndexByte(s, '\t') < 0 {
+		return nil
+	}
+	var widths []int
 	col := 0
+	for i := strings.IndexByte(s, '\t'); i >= 0; i = strings.IndexByte(s, '\t') {
+		col += utf8.RuneCountInString(s[:i])
+		s = s[i+1:]
+		seg := s
+		if j := strings.IndexByte(seg, '\t'); j >= 0 {
+			seg = seg[:j]
+		}
 
+		n := len(widths)
+		if c.style == WordProcessor {
+			n = c.next(col)
+		}
+		stop := c.stop(n)
+		target := stop.Column
+		switch stop.Align {
+		case AlignRight:
+			target -= utf8.RuneCountInString(seg)
+		case AlignCenter:
+			target -= utf8.RuneCountInString(seg) / 2
+		case AlignNumeric:
+			if j := strings.IndexByte(seg, '.'); j >= 0 {
+				seg = seg[:j]
+			}
+			target -= utf8.RuneCountInString(seg)
+		}
+
+		w := target - col
+		if w < 1 {
+			w = 1
+		}
+		widths = append(widths, w)
+		col += w
+	}
+	return widths
+}
+
+// Return the widths in columns of the tabs in line n, in order
+func (t *TkText) tabWidths(n int, s string) []int {
+	if t.elastic {
+		return t.lines.tabs(n)
+	}
+	return t.tabs.widths(s)
+}
+
+// Return the display column of a position
+func (t *TkText) column(pos Position) int {
+	s := t.getLine(pos.Line)
+	return column(s, pos.Char, t.tabWidths(pos.Line, s))
+}
+
+// Expand tabs to spaces, using the given widths for successive tabs
+func expand(s string, widths []int) string {
+	tabs := strings.Count(s, "\t")
+	if tabs == 0 {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + tabs*(widths[0]-1))
 	for _, ch := range s {
 		if ch == '\t' {
-			p[col] = ' '
-			col++
-			for col%tabStop != 0 {
-				p[col] = ' '
-				col++
+			for w := widths[0]; w > 0; w-- {
+				b.WriteByte(' ')
 			}
+			widths = widths[1:]
 		} else {
-			p[col] = ch
-			col++
+			b.WriteRune(ch)
 		}
 	}
-
-	s = string(p[:col])
-	mutex.Unlock()
-	return s
+	return b.String()
 }
 
-// Return width of expanded string in columns
-func columns(s string, tabStop int) int {
+// Return the column at which byte i of s starts
+func column(s string, i int, widths []int) int {
 	col := 0
-	for _, ch := range s {
+	for _, ch := range s[:i] {
 		if ch == '\t' {
-			col += tabStop - col%tabStop
+			col += widths[0]
+			widths = widths[1:]
 		} else {
 			col++
 		}
 	}
 	return col
 }
+
+// Return the byte index of the first character in s that starts at or after
+// the given column, and the column at which that character starts. If there is
+// no such character, the length and width of s are returned.
+func charAt(s string, col int, widths []int) (int, int) {
+	cur := 0
+	for i, ch := range s {
+		if cur >= col {
+			return i, cur
+		}
+		if ch == '\t' {
+			cur += widths[0]
+			widths = widths[1:]
+		} else {
+			cur++
+		}
+	}
+	return len(s), cur
+}
+
+// Return the byte index of the character in s that covers the given column. If
+// there is no such character, the length of s is returned.
+func charCovering(s string, col int, widths []int) int {
+	cur := 0
+	for i, ch := range s {
+		if ch == '\t' {
+			cur += widths[0]
+			widths = widths[1:]
+		} else {
+			cur++
+		}
+		if cur > col {
+			return i
+		}
+	}
+	return len(s)
+}
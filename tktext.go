@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 // BUG(jangler): Unicode is not handled correctly in some situations.
@@ -37,13 +38,15 @@ type WrapMode uint8
 const (
 	None WrapMode = iota // Lines are not wrapped.
 	Char                 // Wrapping line breaks may occur at any character.
+	Word                 // Wrapping line breaks occur after whitespace.
 )
 
 var lineCharRegexp = regexp.MustCompile(`^(\d+)\.(\w+)`)
 var xyRegexp = regexp.MustCompile(`^@(-?\d+)\,(-?\d+)`)
 var countRegexp = regexp.MustCompile(`^ ?([+-]) ?(-?\d+) ?([cil]\w*)`)
+var selRegexp = regexp.MustCompile(`^sel\.(first|last)`)
 var startEndRegexp = regexp.MustCompile(`^ ?(line|word)([se]\w*)`)
-var wordRegexp = regexp.MustCompile(`^\w$`)
+var matchRegexp = regexp.MustCompile(`^ ?matchbracket`)
 
 // Position denotes a position in a text buffer.
 type Position struct {
@@ -72,6 +75,32 @@ type mark struct {
 	name    string
 }
 
+// Adjust the mark's position for the deletion of the text from start to end
+func (m *mark) deleted(start, end Position) {
+	if comparePos(start, m.Position) <= 0 {
+		if comparePos(m.Position, end) <= 0 {
+			m.Position = start
+		} else {
+			if m.Line == end.Line && start.Line == end.Line {
+				m.Char -= end.Char - start.Char
+			}
+			m.Line -= end.Line - start.Line
+		}
+	}
+}
+
+// Adjust the mark's position for the insertion of text from start to end
+func (m *mark) inserted(start, end Position) {
+	if m.Line > start.Line {
+		m.Line += end.Line - start.Line
+	} else if m.Line == start.Line && m.Char >= start.Char {
+		if m.gravity == Right || m.Char > start.Char {
+			m.Line += end.Line - start.Line
+			m.Char += end.Char - start.Char
+		}
+	}
+}
+
 type markSort []*mark
 
 func (a markSort) Len() int      { return len(a) }
@@ -87,26 +116,52 @@ func (a markSort) Less(i, j int) bool {
 	return a[i].name < a[j].name
 }
 
-// TkText is a text buffer. Internally, the contents are stored as a doubly
-// linked list of line strings.
+// TkText is a text buffer. Internally, the contents are stored as a sequence
+// of line strings in chunks that are shared with snapshots of the buffer.
 type TkText struct {
-	lines                *list.List
+	lines                lineStore
 	undoStack, redoStack *list.List
 	marks                map[string]*mark
 	mutex                *sync.RWMutex
 	undo, modified       bool
 	saveEndPos           Position
 	checksum             [md5.Size]byte
-	width, height        int
-	tabStop              int
+	width, height        int // Size of the text display, excluding the gutter
+	viewWidth            int // Width of the display, including the gutter
+	gutter               GutterMode
+	measurer             Measurer
+	spacing              LineSpacing
+	spacings             []spacingRange
+	spacingID            int
+	tabs                 tabConfig
+	elastic              bool
+	folds                []fold
+	foldID               int
+	elided               []lineSpan
+	foldProvider         FoldProvider
+	tokenizer            Tokenizer
+	bracketPairs         [][2]rune
 	wrapMode             WrapMode
 	xScroll, yScroll     int
+	yOffset              int // Pixels of row yScroll scrolled off the top
+	smooth               bool
+	scan                 scanState
+	scrollOff            int  // Display lines kept around the index by See
+	unclamped            bool // Whether edits may have overscrolled the view
+	wordChars            func(rune) bool
+	selUnit              Granularity
+	cursors              []cursor
+	cursorID             int
+	changed              bool
+	damage               damageState
+	diff                 diffState
+	handlers             []func()
 }
 
 // New returns an initialized and empty TkText buffer.
 func New() *TkText {
 	b := TkText{
-		list.New(),
+		newLineStore(""),
 		list.New(), list.New(),
 		make(map[string]*mark),
 		&sync.RWMutex{},
@@ -114,21 +169,41 @@ func New() *TkText {
 		Position{1, 0},
 		md5.Sum([]byte{}),
 		0, 0,
-		8,
+		0,
+		NoGutter,
+		cellMeasurer{},
+		LineSpacing{},
+		nil,
+		0,
+		tabConfig{8, nil, WordProcessor},
+		false,
+		nil,
+		0,
+		nil,
+		nil,
+		nil,
+		defaultBracketPairs,
 		None,
 		0, 0,
+		0,
+		false,
+		scanState{},
+		0,
+		false,
+		defaultWordChars,
+		SelectChar,
+		nil,
+		0,
+		false,
+		damageState{all: true},
+		diffState{},
+		nil,
 	}
-	b.lines.PushBack("")
 	return &b
 }
 
-func (t *TkText) getLine(n int) *list.Element {
-	i, line := 1, t.lines.Front()
-	for i < n {
-		line = line.Next()
-		i++
-	}
-	return line
+func (t *TkText) getLine(n int) string {
+	return t.lines.line(n)
 }
 
 func (t *TkText) parseLineChar(index string) (Position, int, error) {
@@ -152,10 +227,10 @@ func (t *TkText) parseLineChar(index string) (Position, int, error) {
 		pos.Char = 0
 	} else if pos.Line > t.lines.Len() {
 		pos.Line = t.lines.Len()
-		pos.Char = len(t.lines.Back().Value.(string))
+		pos.Char = len(t.lines.last())
 	} else {
 		// Parse char
-		length := len(t.getLine(pos.Line).Value.(string))
+		length := len(t.getLine(pos.Line))
 		if match[2] == "end" {
 			pos.Char = length
 		} else {
@@ -173,6 +248,11 @@ func (t *TkText) parseLineChar(index string) (Position, int, error) {
 	return pos, len(match[0]), nil
 }
 
+// Return the position of the end of the buffer
+func (t *TkText) end() Position {
+	return Position{t.lines.Len(), len(t.lines.last())}
+}
+
 func comparePos(pos1, pos2 Position) int {
 	if pos1.Line != pos2.Line {
 		return pos1.Line - pos2.Line
@@ -180,43 +260,51 @@ func comparePos(pos1, pos2 Position) int {
 	return pos1.Char - pos2.Char
 }
 
-// BBox returns the row and column numbers of the given index on the screen.
-// The resulting values may be beyond the bounds of the screen, indicating
-// that the index is not visible.
+// BBox returns the row and column numbers of the given index on the screen,
+// counting columns from the left edge of the gutter, or in the units of the
+// buffer's Measurer if one is set. The resulting values may be beyond the
+// bounds of the text display, indicating that the index is not visible.
 func (t *TkText) BBox(index string) (x, y int) {
 	t.mutex.RLock()
-	line := expand(t.Get(index+" linestart", index), t.tabStop)
-	x = len(line) - t.xScroll
-	if t.wrapMode == Char {
-		x %= t.width
-	}
-	t.mutex.RUnlock()
-	_, y, _ = t.DLineInfo(index)
-	return
+	defer t.mutex.RUnlock()
+	x, y = t.bbox(t.index(index))
+	return x + t.gutterWidth(), y
+}
+
+func (t *TkText) bbox(pos Position) (x, y int) {
+	x, y, _, _, offset := t.dline(pos)
+	return x, y + offset
 }
 
 // Compare returns a positive integer if index1 is greater than index2, a
 // negative integer if index1 is less than index2, and zero if the indices are
 // equal.
 func (t *TkText) Compare(index1, index2 string) int {
-	return comparePos(t.Index(index1), t.Index(index2))
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return comparePos(t.index(index1), t.index(index2))
 }
 
 // CountChars returns the number of UTF-8 characters between two indices. If
 // index1 is after index2, the result will be a negative number.
 func (t *TkText) CountChars(index1, index2 string) int {
-	pos1, pos2 := t.Index(index1), t.Index(index2)
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.countChars(t.index(index1), t.index(index2))
+}
+
+func (t *TkText) countChars(pos1, pos2 Position) int {
 	reverse := comparePos(pos1, pos2) > 0
 	if reverse {
 		pos1, pos2 = pos2, pos1
 	}
-	line := t.getLine(pos1.Line)
 	n := 0
-	for pos1.Line < pos2.Line {
-		n += len(line.Value.(string)) + 1
-		pos1.Line++
-		line = line.Next()
-	}
+	t.lines.each(pos1.Line, func(i int, s string) bool {
+		if i < pos2.Line {
+			n += len(s) + 1
+		}
+		return i < pos2.Line
+	})
 	n += pos2.Char - pos1.Char
 	if reverse {
 		n = -n
@@ -227,85 +315,131 @@ func (t *TkText) CountChars(index1, index2 string) int {
 // CountLines returns the number of line breaks between two indices. If index1
 // is after index2, the result will be a negative number (or zero).
 func (t *TkText) CountLines(index1, index2 string) int {
-	pos1, pos2 := t.Index(index1), t.Index(index2)
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	pos1, pos2 := t.index(index1), t.index(index2)
 	return pos2.Line - pos1.Line
 }
 
-func countDisplayLinesString(s string, wrap WrapMode, tab, width int) int {
-	n := 0
-	for _, line := range strings.Split(s, "\n") {
-		if line == "" {
-			n++
-			continue
-		}
-		length := len(expand(line, tab))
-		for length > 0 {
-			length -= width
-			n++
-		}
+// Return the number of display lines taken by the first i bytes of line n
+func (t *TkText) displayRows(n int, s string, i int) int {
+	if t.lineHidden(n) {
+		return 0
 	}
-	return n
+	if t.wrapMode == None || t.width <= 0 {
+		return 1
+	}
+	l := t.layoutLine(n, s)
+	rows := sort.SearchInts(l.starts, len(expand(s[:i], t.tabWidths(n, s))))
+	if rows == 0 {
+		return 1
+	}
+	return rows
 }
 
 // CountDisplayLines returns the number of displayed line breaks between two
 // indices, taking wrapping into account. If index1 is after index2, the result
 // will be a negative number (or zero).
 func (t *TkText) CountDisplayLines(index1, index2 string) int {
-	pos1, pos2 := t.Index(index1), t.Index(index2)
 	t.mutex.RLock()
-	wrapMode, tabStop, width := t.wrapMode, t.tabStop, t.width
-	t.mutex.RUnlock()
+	defer t.mutex.RUnlock()
+	return t.countDisplayLines(t.index(index1), t.index(index2))
+}
 
-	if wrapMode == None || width <= 0 {
+func (t *TkText) countDisplayLines(pos1, pos2 Position) int {
+	if (t.wrapMode == None || t.width <= 0) && len(t.elided) == 0 {
 		return pos2.Line - pos1.Line
 	}
-	index1, index2 = pos1.String(), pos2.String()
 	reverse := comparePos(pos1, pos2) > 0
 	if reverse {
-		index1, index2 = index2, index1
+		pos1, pos2 = pos2, pos1
 	}
-	n := countDisplayLinesString(t.Get(index1+" linestart", index2),
-		wrapMode, tabStop, width)
-	n -= countDisplayLinesString(t.Get(index1+" linestart", index1),
-		wrapMode, tabStop, width)
+	n := 0
+	t.lines.each(pos1.Line, func(line int, s string) bool {
+		if line == pos2.Line {
+			n += t.displayRows(line, s, pos2.Char)
+			return false
+		}
+		n += t.displayRows(line, s, len(s))
+		return true
+	})
+	n -= t.displayRows(pos1.Line, t.getLine(pos1.Line), pos1.Char)
 	if reverse {
 		n = -n
 	}
 	return n
 }
 
+// CountXPixels returns the horizontal distance from the left edge of index1 to
+// the left edge of index2 on the display, in columns or in the units of the
+// buffer's Measurer, like the Tk text widget's count -xpixels. Each index is
+// measured from the start of its display line.
+func (t *TkText) CountXPixels(index1, index2 string) int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.countXPixels(t.index(index1), t.index(index2))
+}
+
+func (t *TkText) countXPixels(pos1, pos2 Position) int {
+	x1, _, _ := t.dlineInfo(pos1)
+	x2, _, _ := t.dlineInfo(pos2)
+	return x2 - x1
+}
+
+// CountYPixels returns the vertical distance from the top of the display line
+// containing index1 to the top of the display line containing index2, in lines
+// or in the units of the buffer's Measurer, like the Tk text widget's count
+// -ypixels.
+func (t *TkText) CountYPixels(index1, index2 string) int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.countYPixels(t.index(index1), t.index(index2))
+}
+
+func (t *TkText) countYPixels(pos1, pos2 Position) int {
+	_, y1, _, _, _ := t.dline(pos1)
+	_, y2, _, _, _ := t.dline(pos2)
+	return y2 - y1
+}
+
 // DLineInfo the starting row and column numbers of the display line containing
-// the given index, as well as the width of that line in columns. The resulting
-// values may be beyond the bounds of the screen, indicating that at least part
-// of the line is not visible.
+// the given index, as well as the width of that line in columns. As with BBox,
+// columns are counted from the left edge of the gutter. The row is the top of
+// the display line, including any spacing above it. The resulting values may
+// be beyond the bounds of the text display, indicating that at least part of
+// the line is not visible.
 func (t *TkText) DLineInfo(index string) (x, y, width int) {
 	t.mutex.RLock()
-	isLineEnd := t.Compare(index, index+" lineend") == 0
-	idxStr := index
-	if !isLineEnd {
-		idxStr += "+1c"
-	}
-	y = t.CountDisplayLines("1.0", idxStr) - t.yScroll
-	width = len(expand(t.Get(index+" linestart", index+" lineend"), t.tabStop))
+	defer t.mutex.RUnlock()
+	x, y, width = t.dlineInfo(t.index(index))
+	return x + t.gutterWidth(), y, width
+}
+
+func (t *TkText) dlineInfo(pos Position) (x, y, width int) {
+	x, y, width, _, _ = t.dline(pos)
+	return
+}
+
+// Return the geometry of the display line containing a position: the offset
+// of the position from the start of the display line, the top, width, and
+// height of the display line, and the offset of its text below its top
+func (t *TkText) dline(pos Position) (x, y, width, height, offset int) {
+	pos = t.visiblePos(pos)
+	s := t.getLine(pos.Line)
+	l := t.layoutLine(pos.Line, s)
+	i := len(expand(s[:pos.Char], t.tabWidths(pos.Line, s)))
+	r := l.row(i)
+	x = t.measurer.StringWidth(l.text[l.starts[r]:i])
+	width = t.measurer.StringWidth(l.rowText(r))
+	height, offset = rowHeight(t.lineSpacing(pos.Line), r, len(l.starts))
+	row := t.countDisplayLines(Position{1, 0}, Position{pos.Line, 0}) + r
 	if t.wrapMode == None {
-		x = len(expand(t.Get(index+" linestart", index), t.tabStop))
-	} else { // t.wrapMode == Char
-		line := expand(t.Get(index+" linestart", index), t.tabStop)
-		length := len(line)
-		for length >= t.width {
-			length -= t.width
-			width -= t.width
-		}
-		x = length
-		if width > t.width {
-			width = t.width
-		}
-		if width == 0 && len(line) > 0 && isLineEnd {
-			y++
-		}
+		x -= t.xScroll
+	} else if pos.Char == len(s) && x > 0 && x >= t.width {
+		// The end of a full display line is at the start of the next
+		x, width, row = 0, 0, row+1
 	}
-	x -= t.xScroll
-	t.mutex.RUnlock()
+	y = t.rowTop(row) - t.viewTop()
 	return
 }
 
@@ -314,60 +448,77 @@ func (t *TkText) DLineInfo(index string) (x, y, width int) {
 // Fewer lines may be returned if there are not enough to fill the screen.
 func (t *TkText) GetScreenLines() []string {
 	t.mutex.RLock()
-	lines := make([]string, t.height)
-	n := 0
+	defer t.mutex.RUnlock()
+	return t.getScreenLines()
+}
+
+func (t *TkText) getScreenLines() []string {
+	screen := t.screenLines()
+	lines := make([]string, len(screen))
+	for i, sl := range screen {
+		lines[i] = sl.text
+	}
+	return lines
+}
+
+// A display line on the screen, which is part of the expanded text of a line
+// in the buffer
+type screenLine struct {
+	line   int    // Line number in the buffer
+	offset int    // Byte offset of the text in the expanded line
+	text   string // Text of the display line
+	x      int    // Offset of the text from the left of the display
+	y      int    // Offset of the text from the top of the screen
+	bottom int    // Offset of the bottom of the display line
+}
+
+func (t *TkText) screenLines() []screenLine {
+	var lines []screenLine
+	first, row, y := 1, 0, -t.yOffset
 	if t.wrapMode == None {
-		line := t.getLine(t.yScroll + 1)
-		for line != nil && n < t.height {
-			s := expand(line.Value.(string), t.tabStop)
-			length := len(s)
-			min := t.xScroll
-			if min > length {
-				min = length
-			}
-			max := t.xScroll + t.width
-			if max > length {
-				max = length
-			}
-			lines[n] = s[min:max]
-			n++
-			line = line.Next()
+		first, row = t.lineAtRow(t.yScroll), t.yScroll
+	}
+	t.lines.each(first, func(line int, s string) bool {
+		if y >= t.height {
+			return false
 		}
-	} else { // t.wrapMode == Char
-		y := 0
-		line := t.lines.Front()
-		for line != nil && n < t.height {
-			s := expand(line.Value.(string), t.tabStop)
-			i := 0
-			length := len(s)
-			for (length > 0 || i == 0) && n < t.height {
-				if y >= t.yScroll {
-					if length <= t.width {
-						lines[n] = s
-					} else {
-						lines[n] = s[:t.width]
-					}
-					n++
-				}
-				if length > t.width {
-					s = s[t.width:]
+		if t.lineHidden(line) {
+			return true
+		}
+		l := t.layoutLine(line, s)
+		sp := t.lineSpacing(line)
+		for r := 0; r < len(l.starts) && y < t.height; r++ {
+			if row >= t.yScroll {
+				text := l.rowText(r)
+				offset := l.starts[r]
+				start, end := t.clip(text, 0, t.width)
+				x := 0
+				if t.wrapMode == None {
+					start, end = t.clip(text, t.xScroll, t.xScroll+t.width)
+					offset = start
+					x = t.measurer.StringWidth(text[:start]) - t.xScroll
 				}
-				length -= t.width
-				y++
-				i++
+				h, above := rowHeight(sp, r, len(l.starts))
+				lines = append(lines, screenLine{line, offset, text[start:end],
+					x, y + above, y + h})
+				y += h
 			}
-			line = line.Next()
+			row++
 		}
-	}
-	t.mutex.RUnlock()
-	return lines[:n]
+		return true
+	})
+	return lines
 }
 
+// Return the position at column x and row y of the screen, counting columns
+// from the left edge of the gutter
 func (t *TkText) getPosXY(x, y int) Position {
 	var pos Position
-	var s string
-	x += t.xScroll
-	y += t.yScroll
+	x -= t.gutterWidth()
+	if t.wrapMode == None {
+		x += t.xScroll
+	}
+	y = t.rowAt(t.viewTop() + y)
 	if x < 0 {
 		x = 0
 	}
@@ -375,60 +526,44 @@ func (t *TkText) getPosXY(x, y int) Position {
 		y = 0
 	}
 
+	// Find the display line
+	var l lineLayout
+	r := 0
 	if t.wrapMode == None {
-		if pos.Line = y + 1; pos.Line > t.lines.Len() {
-			pos.Line = t.lines.Len()
-		}
-		s = t.getLine(pos.Line).Value.(string)
-		length := len(expand(s, t.tabStop))
-		if pos.Char = x; pos.Char > length {
-			pos.Char = length
+		if pos.Line = t.lineAtRow(y); pos.Line > t.lines.Len() {
+			pos.Line = t.visibleLine(t.lines.Len())
 		}
-	} else { // t.wrapMode == Char
-		pos.Line = 1
-		line := t.lines.Front()
+		l = t.layoutLine(pos.Line, t.getLine(pos.Line))
+	} else { // Wrapping modes
 		n := 0
-		s = expand(line.Value.(string), t.tabStop)
-		length := len(s)
-		for n < y {
-			for length > t.width && n < y {
-				length -= t.width
-				s = s[t.width:]
-				n++
+		for pos.Line = 1; ; {
+			l = t.layoutLine(pos.Line, t.getLine(pos.Line))
+			if n+len(l.starts) > y {
+				r = y - n
+				break
 			}
-			if n < y {
-				if line.Next() != nil {
-					line = line.Next()
-					s = expand(line.Value.(string), t.tabStop)
-					length = len(s)
-					n++
-					pos.Line++
-				} else {
-					n = y
-				}
+			next := t.nextVisibleLine(pos.Line)
+			if next > t.lines.Len() {
+				r = len(l.starts) - 1
+				break
 			}
+			n += len(l.starts)
+			pos.Line = next
 		}
-
-		if pos.Char = x; pos.Char > length {
-			pos.Char = length
-		}
-		if pos.Char >= t.width {
-			pos.Char = t.width - 1
-		}
-		s = line.Value.(string)
-		totalLen := len(expand(s, t.tabStop))
-		pos.Char += totalLen - length
 	}
 
-	for i := 0; i < pos.Char; i++ {
-		if s[i] == '\t' {
-			pos.Char -= t.tabStop - 1
-			if pos.Char < i {
-				pos.Char = i
-			}
-		}
+	// Find the character in the display line
+	text := l.rowText(r)
+	i := t.runeCovering(text, x)
+	if i == len(text) && i > 0 && (r+1 < len(l.starts) ||
+		t.wrapMode != None && t.measurer.StringWidth(text) >= t.width) {
+		// Past the end of a wrapped display line is its last character
+		_, size := utf8.DecodeLastRuneInString(text)
+		i -= size
 	}
-
+	col := utf8.RuneCountInString(l.text[:l.starts[r]+i])
+	s := t.getLine(pos.Line)
+	pos.Char = charCovering(s, col, t.tabWidths(pos.Line, s))
 	return pos
 }
 
@@ -437,7 +572,10 @@ func (t *TkText) getPosXY(x, y int) Position {
 func (t *TkText) Index(index string) Position {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
+	return t.index(index)
+}
 
+func (t *TkText) index(index string) Position {
 	var pos Position
 
 	// Parse base
@@ -448,8 +586,20 @@ func (t *TkText) Index(index string) Position {
 	} else if strings.HasPrefix(index, "end") {
 		// end
 		pos.Line = t.lines.Len()
-		pos.Char = len(t.lines.Back().Value.(string))
+		pos.Char = len(t.lines.last())
 		index = index[3:]
+	} else if match := selRegexp.FindStringSubmatch(index); match != nil {
+		// sel.first, sel.last
+		ranges := t.selRanges()
+		if len(ranges) == 0 {
+			panic(errors.New("Selection is empty: " + index))
+		}
+		if match[1] == "first" {
+			pos = ranges[0].Start
+		} else {
+			pos = ranges[len(ranges)-1].End
+		}
+		index = index[len(match[0]):]
 	} else if match := xyRegexp.FindStringSubmatch(index); match != nil {
 		// @<x>,<y>
 		x, err := strconv.ParseInt(match[1], 10, 0)
@@ -469,9 +619,9 @@ func (t *TkText) Index(index string) Position {
 			if strings.HasPrefix(index, k) && len(k) > prefixLen {
 				pos = v.Position
 				prefixLen = len(k)
-				index = index[prefixLen:]
 			}
 		}
+		index = index[prefixLen:]
 	}
 
 	if pos.Line == 0 {
@@ -494,14 +644,12 @@ func (t *TkText) Index(index string) Position {
 			if strings.HasPrefix("chars", match[3]) ||
 				strings.HasPrefix("indices", match[3]) {
 				if delta >= 0 {
-					line := t.getLine(pos.Line)
-					length := len(line.Value.(string))
-					for delta+pos.Char > length && line.Next() != nil {
+					length := len(t.getLine(pos.Line))
+					for delta+pos.Char > length && pos.Line < t.lines.Len() {
 						delta -= length - pos.Char + 1
 						pos.Line++
 						pos.Char = 0
-						line = line.Next()
-						length = len(line.Value.(string))
+						length = len(t.getLine(pos.Line))
 					}
 					if delta+pos.Char <= length {
 						pos.Char += delta
@@ -513,7 +661,7 @@ func (t *TkText) Index(index string) Position {
 					for delta > pos.Char && pos.Line > 1 {
 						delta -= pos.Char + 1
 						pos.Line--
-						pos.Char = len(t.getLine(pos.Line).Value.(string))
+						pos.Char = len(t.getLine(pos.Line))
 					}
 					if delta <= pos.Char {
 						pos.Char -= delta
@@ -528,7 +676,7 @@ func (t *TkText) Index(index string) Position {
 				} else if pos.Line > t.lines.Len() {
 					pos.Line = t.lines.Len()
 				}
-				length := len(t.getLine(pos.Line).Value.(string))
+				length := len(t.getLine(pos.Line))
 				if pos.Char >= length {
 					pos.Char = length
 				}
@@ -542,27 +690,25 @@ func (t *TkText) Index(index string) Position {
 				if strings.HasPrefix("start", match[2]) {
 					pos.Char = 0
 				} else if strings.HasPrefix("end", match[2]) {
-					pos.Char = len(t.getLine(pos.Line).Value.(string))
+					pos.Char = len(t.getLine(pos.Line))
 				} else {
 					panic(errors.New("Bad index modifier: " + index))
 				}
 			} else { // match[1] == "word"
-				line := t.getLine(pos.Line).Value.(string)
+				line := t.getLine(pos.Line)
 				if strings.HasPrefix("start", match[2]) {
-					for pos.Char > 0 &&
-						wordRegexp.MatchString(line[pos.Char-1:pos.Char]) {
-						pos.Char--
-					}
+					pos.Char = t.wordStart(line, pos.Char)
 				} else if strings.HasPrefix("end", match[2]) {
-					for pos.Char < len(line) &&
-						wordRegexp.MatchString(line[pos.Char:pos.Char+1]) {
-						pos.Char++
-					}
+					pos.Char = t.wordEnd(line, pos.Char)
 				} else {
 					panic(errors.New("Bad index modifier: " + index))
 				}
 			}
 			index = index[len(match[0]):]
+		} else if match := matchRegexp.FindString(index); match != "" {
+			// matchbracket
+			pos, _ = t.matchBracket(pos)
+			index = index[len(match):]
 		} else {
 			panic(errors.New("Bad index modifier: " + index))
 		}
@@ -576,27 +722,20 @@ func (t *TkText) Index(index string) Position {
 func (t *TkText) Get(index1, index2 string) string {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
+	return t.get(t.index(index1), t.index(index2))
+}
 
-	// Parse indices
-	start, end := t.Index(index1), t.Index(index2)
+func (t *TkText) get(start, end Position) string {
 	if comparePos(start, end) >= 0 {
 		return ""
 	}
 
-	// Find starting line
-	i, line := 1, t.lines.Front()
-	for i < start.Line {
-		line = line.Next()
-		i++
-	}
-
 	// Write text to buffer
 	var text bytes.Buffer
-	for i <= end.Line {
+	t.lines.each(start.Line, func(i int, s string) bool {
 		if i != start.Line {
 			text.WriteString("\n")
 		}
-		s := line.Value.(string)
 		if i == start.Line {
 			if i == end.Line {
 				text.WriteString(s[start.Char:end.Char])
@@ -608,75 +747,50 @@ func (t *TkText) Get(index1, index2 string) string {
 		} else {
 			text.WriteString(s)
 		}
-		line = line.Next()
-		i++
-	}
+		return i < end.Line
+	})
 
 	return text.String()
 }
 
-func (t *TkText) del(startIndex, endIndex string, undo bool) {
-	// Parse indices
-	start := t.Index(startIndex)
-	end := t.Index(endIndex)
-
-	t.mutex.Lock()
-
-	// Find starting line
-	i, line := 1, t.lines.Front()
-	for i < start.Line {
-		line = line.Next()
-		i++
-	}
-
+// Delete the text between two positions and return it. The caller must hold
+// the write lock.
+func (t *TkText) del(start, end Position, undo bool) string {
 	// Delete text
-	b := &bytes.Buffer{}
-	for i <= end.Line {
-		if i == start.Line {
-			s := line.Value.(string)
-			if i == end.Line {
-				line.Value = s[:start.Char] + s[end.Char:]
-				b.WriteString(s[start.Char:end.Char])
-			} else {
-				line.Value = s[:start.Char]
-				b.WriteString(s[start.Char:] + "\n")
-			}
-		} else if i == end.Line {
-			endLine := line.Next()
-			line.Value = line.Value.(string) +
-				endLine.Value.(string)[end.Char:]
-			b.WriteString(endLine.Value.(string)[:end.Char])
-			t.lines.Remove(endLine)
-		} else {
-			next := line.Next()
-			b.WriteString(next.Value.(string) + "\n")
-			t.lines.Remove(next)
-		}
-		i++
-	}
+	deleted := t.get(start, end)
+	first := t.getLine(start.Line)
+	rows := t.displayRows(start.Line, first, len(first))
+	wide := t.reachesRightEdge(start.Line, first)
+	line := first[:start.Char] + t.getLine(end.Line)[end.Char:]
+	t.lines = t.lines.splice(start.Line-1, end.Line, []string{line})
 
 	// Update marks
 	for _, m := range t.marks {
-		if comparePos(start, m.Position) <= 0 {
-			if comparePos(m.Position, end) <= 0 {
-				m.Position = start
-			} else {
-				if m.Line == end.Line && start.Line == end.Line {
-					m.Char -= end.Char - start.Char
-				}
-				m.Line -= end.Line - start.Line
-			}
-		}
+		m.deleted(start, end)
+	}
+	for i := range t.folds {
+		t.folds[i].start.deleted(start, end)
+		t.folds[i].end.deleted(start, end)
+	}
+	for i := range t.spacings {
+		t.spacings[i].start.deleted(start, end)
+		t.spacings[i].end.deleted(start, end)
+	}
+	t.linesChanged(start.Line, end.Line, start.Line)
+	t.damageEdit(start.Line, end.Line, start.Line, rows)
+	t.changed = true
+
+	// Deletion can only overscroll the view by removing display lines, or by
+	// narrowing a line that reaches the right edge of the view
+	if end.Line > start.Line || wide || t.elastic ||
+		t.displayRows(start.Line, line, len(line)) != rows {
+		t.unclamped = t.unclamped || t.scrolled()
 	}
 
-	undo = undo && t.undo
-	t.mutex.Unlock()
-
-	if undo {
+	if undo && t.undo {
 		sp := start.String()
 		ep := end.String()
 		t.redoStack.Init()
-		t.mutex.Lock()
 		front := t.undoStack.Front()
 		collapsed := false
 		if front != nil {
@@ -684,79 +798,78 @@ func (t *TkText) del(startIndex, endIndex string, undo bool) {
 			case deleteOp:
 				if v.sp == sp {
 					ep = fmt.Sprintf("%s +%dc", ep, len(v.s))
-					front.Value = deleteOp{sp, ep, v.s + b.String()}
+					front.Value = deleteOp{sp, ep, v.s + deleted}
 					collapsed = true
 				} else if v.sp == ep {
 					ep = fmt.Sprintf("%s +%dc", ep, len(v.s))
-					front.Value = deleteOp{sp, ep, b.String() + v.s}
+					front.Value = deleteOp{sp, ep, deleted + v.s}
 					collapsed = true
 				}
 			}
 		}
 		if !collapsed {
-			t.undoStack.PushFront(deleteOp{sp, ep, b.String()})
+			t.undoStack.PushFront(deleteOp{sp, ep, deleted})
 		}
-		t.mutex.Unlock()
 	}
+
+	return deleted
 }
 
 // Delete deletes the text from index1 to index2. If index1 is after index2, no
 // text is deleted. If the undo mechanism is enabled for the buffer, the
 // operation is pushed onto the undo stack, and the redo stack is cleared.
 func (t *TkText) Delete(index1, index2 string) {
-	if t.Compare(index1, index2) < 0 {
-		t.del(index1, index2, true)
-	}
-}
-
-func (t *TkText) insert(index, s string, undo bool) {
-	start := t.Index(index)
-
+	defer t.notify()
 	t.mutex.Lock()
-
-	// Find insert index
-	i, line := 1, t.lines.Front()
-	for i < start.Line && line.Next() != nil {
-		line = line.Next()
-		i++
+	defer t.unlock()
+	if start, end := t.index(index1), t.index(index2); comparePos(start,
+		end) < 0 {
+		t.del(start, end, true)
 	}
+}
 
+// Insert text at a position and return the position of the end of the
+// inserted text. The caller must hold the write lock.
+func (t *TkText) insert(start Position, s string, undo bool) Position {
 	// Insert lines
-	startLine := line
+	line := t.getLine(start.Line)
 	lines := strings.Split(s, "\n")
-	for _, insertLine := range lines {
-		line = t.lines.InsertAfter(insertLine, line)
+	last := len(lines) - 1
+	end := Position{start.Line + last, len(lines[last])}
+	if last == 0 {
+		end.Char += start.Char
 	}
+	lines[0] = line[:start.Char] + lines[0]
+	lines[last] += line[start.Char:]
+	rows := t.displayRows(start.Line, line, len(line))
+	wide := t.reachesRightEdge(start.Line, line)
+	t.lines = t.lines.splice(start.Line-1, start.Line, lines)
 
 	// Update marks
 	for _, m := range t.marks {
-		if m.Line > start.Line {
-			m.Line += len(lines) - 1
-		} else if m.Line == start.Line && m.Char >= start.Char {
-			if m.gravity == Right || m.Char > start.Char {
-				m.Line += len(lines) - 1
-				if len(lines) == 1 {
-					m.Char += len(s)
-				} else {
-					m.Char += len(line.Value.(string)) - start.Char
-				}
-			}
-		}
+		m.inserted(start, end)
+	}
+	for i := range t.folds {
+		t.folds[i].start.inserted(start, end)
+		t.folds[i].end.inserted(start, end)
+	}
+	for i := range t.spacings {
+		t.spacings[i].start.inserted(start, end)
+		t.spacings[i].end.inserted(start, end)
+	}
+	t.linesChanged(start.Line, start.Line, end.Line)
+	t.damageEdit(start.Line, start.Line, end.Line, rows)
+	t.changed = true
+
+	// Insertion can only overscroll the view by splitting a line that reaches
+	// the right edge of the view, or by narrowing elastic tabs
+	if wide && end.Line > start.Line || t.elastic {
+		t.unclamped = t.unclamped || t.scrolled()
 	}
 
-	// Splice initial line together with inserted lines
-	line.Value = line.Value.(string) + startLine.Value.(string)[start.Char:]
-	startLine.Value = startLine.Value.(string)[:start.Char] +
-		t.lines.Remove(startLine.Next()).(string)
-
-	undo = undo && t.undo
-	t.mutex.Unlock()
-
-	if undo {
+	if undo && t.undo {
 		sp := start.String()
-		end := t.Index(fmt.Sprintf("%s +%dc", start.String(), len(s)))
 		ep := end.String()
-		t.mutex.Lock()
 		t.redoStack.Init()
 		front := t.undoStack.Front()
 		collapsed := false
@@ -767,10 +880,7 @@ func (t *TkText) insert(index, s string, undo bool) {
 					front.Value = insertOp{v.sp, ep, v.s + s}
 					collapsed = true
 				} else if v.sp == sp {
-					t.mutex.Unlock()
-					end = t.Index(fmt.Sprintf("%s +%dc", index, len(s+v.s)))
-					t.mutex.Lock()
-					ep = end.String()
+					ep = t.index(fmt.Sprintf("%s +%dc", sp, len(s+v.s))).String()
 					front.Value = insertOp{sp, ep, s + v.s}
 					collapsed = true
 				}
@@ -779,7 +889,31 @@ func (t *TkText) insert(index, s string, undo bool) {
 		if !collapsed {
 			t.undoStack.PushFront(insertOp{sp, ep, s})
 		}
-		t.mutex.Unlock()
+	}
+
+	return end
+}
+
+// Update state derived from the contents of lines first through newLast,
+// which have just replaced lines first through oldLast
+func (t *TkText) linesChanged(first, oldLast, newLast int) {
+	if t.elastic {
+		t.relayoutElastic(first, newLast)
+	}
+	if t.foldProvider != nil {
+		t.rescanFolds(first, newLast)
+	}
+	if t.tokenizer != nil {
+		t.relex(first, newLast)
+	}
+	if len(t.folds) > 0 {
+		t.updateFolds()
+	}
+	if t.gutter != NoGutter {
+		t.layoutWidth()
+	}
+	if t.diff.base != nil {
+		t.rediff(first, oldLast, newLast)
 	}
 }
 
@@ -788,7 +922,10 @@ func (t *TkText) insert(index, s string, undo bool) {
 // the redo stack is cleared.
 func (t *TkText) Insert(index, s string) {
 	if s != "" {
-		t.insert(index, s, true)
+		defer t.notify()
+		t.mutex.Lock()
+		defer t.unlock()
+		t.insert(t.index(index), s, true)
 	}
 }
 
@@ -797,9 +934,16 @@ func (t *TkText) Insert(index, s string) {
 // index1. If the undo mechanism is enabled for the buffer, the operation is
 // pushed onto the undo stack, and the redo stack is cleared.
 func (t *TkText) Replace(index1, index2, s string) {
-	index1 = t.Index(index1).String()
-	t.Delete(index1, index2)
-	t.Insert(index1, s)
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+	start, end := t.index(index1), t.index(index2)
+	if comparePos(start, end) < 0 {
+		t.del(start, end, true)
+	}
+	if s != "" {
+		t.insert(start, s, true)
+	}
 }
 
 // MarkGetGravity returns the gravity of the mark with the given name, or an
@@ -818,7 +962,7 @@ func (t *TkText) MarkGetGravity(name string) (Gravity, error) {
 // an error if a mark with the given name is not set.
 func (t *TkText) MarkSetGravity(name string, direction Gravity) error {
 	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	defer t.unlock()
 	m := t.marks[name]
 	if m == nil {
 		return fmt.Errorf("mark does not exist: %s", name)
@@ -830,13 +974,17 @@ func (t *TkText) MarkSetGravity(name string, direction Gravity) error {
 // MarkNames returns a slice of names of marks that are currently set.
 func (t *TkText) MarkNames() []string {
 	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.markNames()
+}
+
+func (t *TkText) markNames() []string {
 	names := make([]string, len(t.marks))
 	i := 0
 	for k := range t.marks {
 		names[i] = k
 		i++
 	}
-	t.mutex.RUnlock()
 	return names
 }
 
@@ -860,10 +1008,10 @@ func (t *TkText) sortedMarks(reverse bool) []*mark {
 // is returned if no mark is found. This function can be used to step through
 // all set marks in order.
 func (t *TkText) MarkNext(index string) string {
-	pos := t.Index(index)
-	marks := t.sortedMarks(false)
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
+	pos := t.index(index)
+	marks := t.sortedMarks(false)
 	indexIsMark := t.marks[index] != nil
 	for _, m := range marks {
 		if m.Line > pos.Line || (m.Line == pos.Line && (m.Char > pos.Char ||
@@ -879,10 +1027,10 @@ func (t *TkText) MarkNext(index string) string {
 // string is returned if no mark is found. This function can be used to step
 // through all set marks in reverse order.
 func (t *TkText) MarkPrevious(index string) string {
-	pos := t.Index(index)
-	marks := t.sortedMarks(true)
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
+	pos := t.index(index)
+	marks := t.sortedMarks(true)
 	indexIsMark := t.marks[index] != nil
 	for _, m := range marks {
 		if m.Line < pos.Line || (m.Line == pos.Line && (m.Char < pos.Char ||
@@ -896,24 +1044,19 @@ func (t *TkText) MarkPrevious(index string) string {
 // MarkSet sets a mark with the given name at the given index. If a mark with
 // the given name is already set, its position is updated.
 func (t *TkText) MarkSet(name, index string) {
-	pos := t.Index(index)
 	t.mutex.Lock()
-	if m := t.marks[name]; m != nil {
-		m.Position = pos
-	} else {
-		t.marks[name] = &mark{pos, Right, name}
-	}
-	t.mutex.Unlock()
+	defer t.unlock()
+	t.setMark(name, t.index(index))
 }
 
 // MarkUnset removes the marks with the given names. It is not an error to
 // remove a mark that is not set.
 func (t *TkText) MarkUnset(name ...string) {
 	t.mutex.Lock()
+	defer t.unlock()
 	for _, k := range name {
 		delete(t.marks, k)
 	}
-	t.mutex.Unlock()
 }
 
 // EditGetModified returns true if and only if the buffer contents differ from
@@ -925,11 +1068,11 @@ func (t *TkText) EditGetModified() bool {
 	if t.modified {
 		return true
 	}
-	endPos := Position{t.lines.Len(), len(t.lines.Back().Value.(string))}
+	endPos := Position{t.lines.Len(), len(t.lines.last())}
 	if endPos != t.saveEndPos {
 		return true
 	}
-	return t.checksum != md5.Sum([]byte(t.Get("1.0", "end")))
+	return t.checksum != md5.Sum([]byte(t.get(Position{1, 0}, endPos)))
 }
 
 // EditSetModified sets the modified flag of the widget. If the flag is set to
@@ -938,21 +1081,19 @@ func (t *TkText) EditGetModified() bool {
 // EditGetModified always returns true.
 func (t *TkText) EditSetModified(modified bool) {
 	t.mutex.Lock()
+	defer t.unlock()
 	t.modified = modified
 	if !modified {
 		t.saveEndPos = Position{t.lines.Len(),
-			len(t.lines.Back().Value.(string))}
-		t.mutex.Unlock()
-		contents := t.Get("1.0", "end")
-		t.mutex.Lock()
-		t.checksum = md5.Sum([]byte(contents))
+			len(t.lines.last())}
+		t.checksum = md5.Sum([]byte(t.get(Position{1, 0}, t.saveEndPos)))
 	}
-	t.mutex.Unlock()
 }
 
 func (t *TkText) setMarks(index string, name ...string) {
+	pos := t.index(index)
 	for _, n := range name {
-		t.MarkSet(n, index)
+		t.setMark(n, pos)
 	}
 }
 
@@ -962,11 +1103,12 @@ func (t *TkText) setMarks(index string, name ...string) {
 // If mark names are given as arguments, the corresponding marks are set to the
 // position of the undone change.
 func (t *TkText) EditUndo(name ...string) bool {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
 	i, loop := 0, true
 	for loop {
-		t.mutex.RLock()
 		front := t.undoStack.Front()
-		t.mutex.RUnlock()
 		if front == nil {
 			break
 		}
@@ -976,16 +1118,14 @@ func (t *TkText) EditUndo(name ...string) bool {
 				loop = false
 			}
 		case insertOp:
-			t.del(v.sp, v.ep, false)
+			t.del(t.index(v.sp), t.index(v.ep), false)
 			t.setMarks(v.sp, name...)
 		case deleteOp:
-			t.insert(v.sp, v.s, false)
+			t.insert(t.index(v.sp), v.s, false)
 			t.setMarks(v.ep, name...)
 		}
 		if loop {
-			t.mutex.Lock()
 			t.redoStack.PushFront(t.undoStack.Remove(front))
-			t.mutex.Unlock()
 			i++
 		}
 	}
@@ -998,11 +1138,12 @@ func (t *TkText) EditUndo(name ...string) bool {
 // If mark names are given as arguments, the corresponding marks are set to the
 // position of the redone change.
 func (t *TkText) EditRedo(name ...string) bool {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
 	i, loop, redone := 0, true, false
 	for loop {
-		t.mutex.RLock()
 		front := t.redoStack.Front()
-		t.mutex.RUnlock()
 		if front == nil {
 			break
 		}
@@ -1012,18 +1153,16 @@ func (t *TkText) EditRedo(name ...string) bool {
 				loop = false
 			}
 		case insertOp:
-			t.insert(v.sp, v.s, false)
+			t.insert(t.index(v.sp), v.s, false)
 			t.setMarks(v.ep, name...)
 			redone = true
 		case deleteOp:
-			t.del(v.sp, v.ep, false)
+			t.del(t.index(v.sp), t.index(v.ep), false)
 			t.setMarks(v.sp, name...)
 			redone = true
 		}
 		if loop {
-			t.mutex.Lock()
 			t.undoStack.PushFront(t.redoStack.Remove(front))
-			t.mutex.Unlock()
 			i++
 		}
 	}
@@ -1034,6 +1173,11 @@ func (t *TkText) EditRedo(name ...string) bool {
 // already on top and the stack is not empty.
 func (t *TkText) EditSeparator() {
 	t.mutex.Lock()
+	defer t.unlock()
+	t.separate()
+}
+
+func (t *TkText) separate() {
 	front := t.undoStack.Front()
 	var sep separator
 	if front != nil {
@@ -1044,85 +1188,179 @@ func (t *TkText) EditSeparator() {
 			t.undoStack.PushFront(sep)
 		}
 	}
-	t.mutex.Unlock()
 }
 
 // EditReset clears the undo and redo stacks.
 func (t *TkText) EditReset() {
 	t.mutex.Lock()
+	defer t.unlock()
 	t.undoStack.Init()
 	t.redoStack.Init()
-	t.mutex.Unlock()
 }
 
-// See adjusts the view so that the given index is visible. If the index is
-// already visible, no adjustment is made. If the index is less than one page
-// out of view, the view is adjusted so that the index is at the edge of the
-// screen. Otherwise, the view is centered on the index.
-func (t *TkText) See(index string) {
-	x, y := t.BBox(index)
-	coords := []int{x, y}
-	vars := [][]*int{
-		[]*int{&t.xScroll, &t.width},
-		[]*int{&t.yScroll, &t.height},
+// OnChange registers a function to be called after each change to the
+// contents of the buffer. Changes made within a transaction are reported once,
+// when the transaction is committed. The function is called without any lock
+// held, so it may safely call methods of the buffer.
+func (t *TkText) OnChange(f func()) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.handlers = append(t.handlers, f)
+}
+
+// Call change handlers if the buffer has changed since the last call
+// Report whether the view is scrolled away from the top left of the buffer
+func (t *TkText) scrolled() bool {
+	return t.xScroll > 0 || t.yScroll > 0 || t.yOffset != 0
+}
+
+// Report whether line n, whose text is s, reaches the right edge of a
+// horizontally scrolled view
+func (t *TkText) reachesRightEdge(n int, s string) bool {
+	return t.xScroll > 0 && t.measurer.StringWidth(expand(s,
+		t.tabWidths(n, s))) >= t.xScroll+t.width
+}
+
+// Clamp the view if edits may have overscrolled it, and release the write
+// lock. Clamping is deferred to the end of an operation, since it measures
+// the whole buffer.
+func (t *TkText) unlock() {
+	if t.unclamped {
+		t.clampView()
+		t.unclamped = false
 	}
+	t.mutex.Unlock()
+}
+
+func (t *TkText) notify() {
 	t.mutex.Lock()
-	for i, v := range vars {
-		if coords[i] < -*v[1] {
-			*v[0] += coords[i] - *v[1]/2
-		} else if coords[i] < 0 {
-			*v[0] += coords[i]
-		} else if coords[i] >= *v[1]*2 {
-			*v[0] += coords[i] - *v[1]/2
-		} else if coords[i] >= *v[1] {
-			*v[0] += coords[i] - *v[1] + 1
-		}
-		if *v[0] < 0 {
-			*v[0] = 0
+	changed, handlers := t.changed, t.handlers
+	t.changed = false
+	t.mutex.Unlock()
+	if changed {
+		for _, f := range handlers {
+			f()
 		}
 	}
-	t.mutex.Unlock()
+}
+
+// See adjusts the view so that the given index is visible, like the Tk text
+// widget's see command. If the index is already visible, no adjustment is
+// made. If the index is out of view by less than a third of the screen, the
+// view is adjusted so that the index is at the edge of the screen. Otherwise,
+// the view is centered on the index. Vertically, the whole display line
+// containing the index is brought into view, along with the margin of display
+// lines set by SetScrollOff.
+func (t *TkText) See(index string) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.see(t.index(index))
 }
 
 // SetSize sets the text display's width and height in characters and lines,
-// respectively.
+// respectively, or in the units of the buffer's Measurer if one is set. The
+// width includes the gutter, if one is set.
 func (t *TkText) SetSize(width, height int) {
 	t.mutex.Lock()
-	t.width, t.height = width, height
-	t.mutex.Unlock()
+	defer t.unlock()
+	t.viewWidth, t.height = width, height
+	t.layoutWidth()
+	t.clampView()
 }
 
-// SetTabStop sets the width in characters of the text display's tab stops.
-// The default is 8.
-func (t *TkText) SetTabStop(width int) {
+// SetTabStop sets the width in characters of the text display's tab stops,
+// which are used when no list of tab stops has been set with SetTabs. The
+// default is 8. Returns an error if width is not positive.
+func (t *TkText) SetTabStop(width int) error {
+	if width < 1 {
+		return errors.New("tab stop width must be positive")
+	}
 	t.mutex.Lock()
-	t.tabStop = width
-	t.mutex.Unlock()
+	defer t.unlock()
+	t.tabs.width = width
+	t.damageAll()
+	if t.elastic {
+		t.layoutElastic(1, t.lines.Len())
+	}
+	t.clampView()
+	return nil
+}
+
+// SetTabs sets the text display's tab stops, like the Tk text widget's -tabs
+// option. Tabs past the last stop use stops extrapolated from the interval
+// between the last two stops (or from the column of the stop, if only one is
+// given) and the alignment of the last stop. Calling SetTabs with no stops
+// restores uniform tab stops of the width set by SetTabStop. Returns an error
+// if the columns of the stops are not positive and increasing.
+func (t *TkText) SetTabs(stops ...TabStop) error {
+	if err := checkTabs(stops); err != nil {
+		return err
+	}
+	stops = append([]TabStop(nil), stops...)
+	if len(stops) == 0 {
+		stops = nil
+	}
+	t.mutex.Lock()
+	defer t.unlock()
+	t.tabs.stops = stops
+	t.damageAll()
+	t.clampView()
+	return nil
+}
+
+// SetTabStyle sets how tabs are matched to tab stops, like the Tk text
+// widget's -tabstyle option. The default is WordProcessor, which matches the
+// behavior of uniform tab stops; note that Tk's default is tabular.
+func (t *TkText) SetTabStyle(style TabStyle) {
+	t.mutex.Lock()
+	defer t.unlock()
+	t.tabs.style = style
+	t.damageAll()
+	t.clampView()
 }
 
 // SetUndo enables or disables the undo mechanism for the buffer. The mechanism
 // is enabled by default.
 func (t *TkText) SetUndo(enabled bool) {
 	t.mutex.Lock()
+	defer t.unlock()
 	t.undo = enabled
-	t.mutex.Unlock()
+}
+
+// SetWordChars sets the predicate used by the wordstart and wordend index
+// modifiers to decide whether a character is part of a word. Runs of
+// whitespace and runs of other non-word characters are treated as words of
+// their own. A nil predicate restores the default, which accepts Unicode
+// letters, digits, and underscores.
+func (t *TkText) SetWordChars(f func(rune) bool) {
+	if f == nil {
+		f = defaultWordChars
+	}
+	t.mutex.Lock()
+	defer t.unlock()
+	t.wordChars = f
 }
 
 // SetWrap sets the wrap mode of the text display. The default is None.
 func (t *TkText) SetWrap(mode WrapMode) {
 	t.mutex.Lock()
+	defer t.unlock()
 	t.wrapMode = mode
-	t.mutex.Unlock()
+	t.clampView()
 }
 
 func (t *TkText) maxLine() int {
 	maxLen := 0
-	for line := t.lines.Front(); line != nil; line = line.Next() {
-		length := columns(line.Value.(string), t.tabStop)
-		if length > maxLen {
+	t.lines.each(1, func(n int, s string) bool {
+		if t.lineHidden(n) {
+			return true
+		}
+		s = expand(s, t.tabWidths(n, s))
+		if length := t.measurer.StringWidth(s); length > maxLen {
 			maxLen = length
 		}
-	}
+		return true
+	})
 	return maxLen
 }
 
@@ -1131,6 +1369,11 @@ func (t *TkText) maxLine() int {
 // second describes the fraction that are NOT off-screen to the right.
 func (t *TkText) XView() (left, right float64) {
 	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.xview()
+}
+
+func (t *TkText) xview() (left, right float64) {
 	maxLen := t.maxLine()
 	if t.wrapMode != None && maxLen > t.width {
 		maxLen = t.width
@@ -1144,7 +1387,6 @@ func (t *TkText) XView() (left, right float64) {
 	} else {
 		right = 1
 	}
-	t.mutex.RUnlock()
 	return
 }
 
@@ -1152,33 +1394,44 @@ func (t *TkText) XView() (left, right float64) {
 // buffer are off-screen to the left.
 func (t *TkText) XViewMoveTo(fraction float64) {
 	t.mutex.Lock()
+	defer t.unlock()
 	maxLen := t.maxLine()
 	t.xScroll = int(fraction * float64(maxLen))
-	t.mutex.Unlock()
+	t.clampView()
 }
 
 // XViewScroll shifts the horizontal scrolling right by the given number of
 // columns.
 func (t *TkText) XViewScroll(chars int) {
 	t.mutex.Lock()
+	defer t.unlock()
+	t.xviewScroll(chars)
+}
+
+func (t *TkText) xviewScroll(chars int) {
 	t.xScroll += chars
-	if maxLen := t.maxLine(); t.xScroll > maxLen-t.width {
-		t.xScroll = maxLen - t.width
-	} else if t.xScroll < 0 {
-		t.xScroll = 0
-	}
-	t.mutex.Unlock()
+	t.clampView()
+}
+
+// Return the number of display lines in the buffer, plus one
+func (t *TkText) displayLines() int {
+	return t.countDisplayLines(Position{1, 0}, t.end()) + 1
 }
 
 // YView returns two fractions in the range [0, 1]. The first describes the
 // fraction of lines in the buffer that are off-screen to the top, and the
 // second describes the fraction that are NOT off-screen to the bottom.
 func (t *TkText) YView() (top, bottom float64) {
-	nLines := t.CountDisplayLines("1.0", "end") + 1
 	t.mutex.RLock()
-	top = float64(t.yScroll) / float64(nLines)
-	bottom = float64(t.yScroll+t.height) / float64(nLines)
-	t.mutex.RUnlock()
+	defer t.mutex.RUnlock()
+	return t.yview()
+}
+
+func (t *TkText) yview() (top, bottom float64) {
+	total := float64(t.rowTop(t.displayLines()))
+	y := t.viewTop()
+	top = float64(y) / total
+	bottom = float64(y+t.height) / total
 	if bottom > 1 {
 		bottom = 1
 	}
@@ -1188,21 +1441,22 @@ func (t *TkText) YView() (top, bottom float64) {
 // YViewMoveTo adjusts the view so that the given fraction of lines in the
 // buffer are off-screen to the top.
 func (t *TkText) YViewMoveTo(fraction float64) {
-	nLines := t.CountDisplayLines("1.0", "end") + 1
 	t.mutex.Lock()
-	t.yScroll = int(fraction * float64(nLines))
-	t.mutex.Unlock()
+	defer t.unlock()
+	total := t.rowTop(t.displayLines())
+	t.scrollTo(int(fraction * float64(total)))
+	t.clampView()
 }
 
 // YViewScroll shifts the vertical scrolling down by the given number of lines.
 func (t *TkText) YViewScroll(lines int) {
-	nLines := t.CountDisplayLines("1.0", "end") + 1
 	t.mutex.Lock()
+	defer t.unlock()
+	t.yviewScroll(lines)
+}
+
+func (t *TkText) yviewScroll(lines int) {
 	t.yScroll += lines
-	if t.yScroll > nLines-t.height {
-		t.yScroll = nLines - t.height
-	} else if t.yScroll < 0 {
-		t.yScroll = 0
-	}
-	t.mutex.Unlock()
+	t.yOffset = 0
+	t.clampView()
 }
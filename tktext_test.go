@@ -1,11 +1,17 @@
 package tktext
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 func poscmp(t *testing.T, got Position, wantLine, wantChar int) {
@@ -49,6 +55,32 @@ func TestParse(t *testing.T) {
 			text.Get("1.0", pos)
 		}()
 	}
+
+	// Bad indices passed to functions that lock the buffer for writing do not
+	// leave it locked
+	edits := []func(string){
+		func(pos string) { text.Insert(pos, "x") },
+		func(pos string) { text.Delete("1.0", pos) },
+		func(pos string) { text.Replace(pos, "end", "x") },
+		func(pos string) { text.MarkSet("m", pos) },
+		func(pos string) { text.See(pos) },
+		func(pos string) { text.SelectionSet("1.0", pos) },
+		func(pos string) { text.CursorAdd(pos, pos) },
+		func(pos string) { text.BlockInsert(pos, []string{"x"}) },
+		func(pos string) { text.FoldAdd("1.0", pos) },
+	}
+	for _, edit := range edits {
+		func() {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Error("Bad position did not cause panic")
+				}
+			}()
+			edit("bad")
+		}()
+		text.Insert("end", "ok")
+	}
+	intcmp(t, len(text.Get("1.0", "end")), 2*len(edits))
 }
 
 func TestBBox(t *testing.T) {
@@ -76,6 +108,32 @@ func TestBBox(t *testing.T) {
 	}
 }
 
+func TestBlock(t *testing.T) {
+	text := New()
+	text.Insert("1.0", "a = 1\nbb\tc = 2\nd")
+	lines := text.BlockGet("1.1", "3.0")
+	if len(lines) != 3 || lines[0] != "a" || lines[1] != "b" || lines[2] != "d" {
+		t.Errorf("BlockGet returned %#v", lines)
+	}
+	lines = text.BlockGet("2.3", "1.2")
+	if len(lines) != 2 || lines[0] != "= 1" || lines[1] != "\t" {
+		t.Errorf("BlockGet returned %#v", lines)
+	}
+
+	text.EditSeparator()
+	text.BlockInsert("1.5", []string{"!", "!", "!", "!"})
+	strcmp(t, text.Get("1.0", "end"), "a = 1!\nbb\t!c = 2\nd    !\n     !")
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "a = 1\nbb\tc = 2\nd")
+
+	text.BlockDelete("1.0", "2.1")
+	strcmp(t, text.Get("1.0", "end"), " = 1\nb\tc = 2\nd")
+	text.BlockReplace("1.0", "3.1", []string{"x", "y"})
+	strcmp(t, text.Get("1.0", "end"), "x= 1\ny\tc = 2\n")
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), " = 1\nb\tc = 2\nd")
+}
+
 func TestCompare(t *testing.T) {
 	text := New()
 	text.Insert("1.0", "hello\nworld")
@@ -202,6 +260,1399 @@ func TestGetScreenLines(t *testing.T) {
 	}
 }
 
+func TestTabs(t *testing.T) {
+	stops, err := ParseTabs("4 10 r 16 numeric")
+	if err != nil {
+		t.Fatalf("ParseTabs returned %v", err)
+	}
+	want := []TabStop{{4, AlignLeft}, {10, AlignRight}, {16, AlignNumeric}}
+	if fmt.Sprint(stops) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", stops, want)
+	}
+	for _, spec := range []string{"4 2", "right 4", "4 bogus", "0"} {
+		if _, err := ParseTabs(spec); err == nil {
+			t.Errorf("ParseTabs(%#v) returned nil error", spec)
+		}
+	}
+
+	text := New()
+	text.SetSize(40, 5)
+	text.Insert("end", "a\tbb\tc\t3.14\t2.5\nabcdef\tx\ty")
+	if err := text.SetTabs(stops...); err != nil {
+		t.Errorf("SetTabs returned %v", err)
+	}
+	lines := text.GetScreenLines()
+	strcmp(t, lines[0], "a   bb   c     3.14  2.5")
+	strcmp(t, lines[1], "abcdef   x     y")
+	x, y := text.BBox("1.5")
+	intcmp(t, x, 9)
+	intcmp(t, y, 0)
+	_, _, width := text.DLineInfo("1.0")
+	intcmp(t, width, 24)
+	poscmp(t, text.Index("@9,0"), 1, 5)
+	poscmp(t, text.Index("@7,0"), 1, 4)
+
+	text.SetTabStyle(Tabular)
+	strcmp(t, text.GetScreenLines()[1], "abcdef x y")
+	text.SetTabs(TabStop{6, AlignCenter})
+	text.Replace("1.0", "end", "\tabcd\tef")
+	strcmp(t, text.GetScreenLines()[0], "    abcd   ef")
+	text.SetTabs()
+	strcmp(t, text.GetScreenLines()[0], "        abcd    ef")
+
+	if err := text.SetTabStop(0); err == nil {
+		t.Error("SetTabStop(0) returned nil error")
+	}
+}
+
+func TestElasticTabs(t *testing.T) {
+	text := New()
+	text.SetSize(40, 10)
+	text.SetTabStop(4)
+	text.Insert("end", "a\tb\tc\nlonger\tx\ty\n\nfoo\tbar")
+	text.SetElasticTabs(true)
+	want := []string{"a      b   c", "longer x   y", "", "foo bar"}
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), strings.Join(want, "|"))
+	snap := text.Snapshot()
+
+	text.Insert("1.0", "widerstill")
+	want = []string{"widerstilla b   c", "longer      x   y", "", "foo bar"}
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), strings.Join(want, "|"))
+	x, y := text.BBox("2.7")
+	intcmp(t, x, 12)
+	intcmp(t, y, 1)
+	_, _, width := text.DLineInfo("2.0")
+	intcmp(t, width, 17)
+	poscmp(t, text.Index("@9,1"), 2, 6)
+	strcmp(t, snap.GetScreenLines()[1], "longer x   y")
+
+	text.Delete("2.0", "3.0")
+	text.Insert("2.0", "x\ty\n")
+	want = []string{"widerstilla b   c", "x           y", "", "foo bar"}
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), strings.Join(want, "|"))
+	text.SetElasticTabs(false)
+	strcmp(t, text.GetScreenLines()[1], "x   y")
+
+	// Edits to wrapped lines measure the new line's tabs
+	text = New()
+	text.SetSize(10, 5)
+	text.SetWrap(Char)
+	text.SetElasticTabs(true)
+	text.Insert("1.0", "a\tb")
+	text.Insert("end", "\nccccccc\td")
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), "a       b|ccccccc d")
+	text.Delete("2.0", "2.2")
+	text.Delete("1.1", "1.2")
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), "ab|ccccc   d")
+	text.Damage()
+}
+
+func TestFold(t *testing.T) {
+	text := New()
+	text.SetSize(20, 5)
+	for i := 1; i <= 10; i++ {
+		text.Insert("end", fmt.Sprintf("line%d\n", i))
+	}
+	text.Delete("end -1c", "end")
+	intcmp(t, text.FoldAdd("2.0", "5.0"), 1)
+	intcmp(t, text.FoldAdd("9.0", "7.3"), 2)
+	intcmp(t, text.FoldAdd("3.0", "3.end"), 0)
+
+	want := "line1|line2|line6|line7|line10"
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), want)
+	intcmp(t, text.CountDisplayLines("1.0", "end"), 4)
+	_, y, _ := text.DLineInfo("6.0")
+	intcmp(t, y, 2)
+	x, y := text.BBox("4.2")
+	intcmp(t, x, 5)
+	intcmp(t, y, 1)
+	poscmp(t, text.Index("@0,2"), 6, 0)
+	if !text.Elided("4.0") || text.Elided("2.0") {
+		t.Error("Elided returned wrong results")
+	}
+	text.See("9.0")
+	strcmp(t, text.GetScreenLines()[0], "line1")
+
+	// Nesting and toggling
+	intcmp(t, text.FoldAdd("3.0", "4.0"), 3)
+	text.FoldSetClosed(1, false)
+	want = "line1|line2|line3|line5|line6"
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), want)
+	text.FoldToggle(3)
+	want = "line1|line2|line3|line4|line5"
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), want)
+	if text.FoldToggle(4) {
+		t.Error("FoldToggle returned true for nonexistent fold")
+	}
+	folds := fmt.Sprint(text.Folds())
+	strcmp(t, folds, "[{1 {2.0 5.5} false} {3 {3.0 4.5} false} {2 {7.0 9.5} true}]")
+
+	// Editing
+	text.Insert("1.0", "new\n")
+	text.Delete("3.0", "6.0")
+	strcmp(t, fmt.Sprint(text.Folds()), "[{2 {5.0 7.5} true}]")
+	text.Delete("5.0", "7.0")
+	strcmp(t, fmt.Sprint(text.Folds()), "[]")
+
+	// Wrapping
+	text.SetWrap(Char)
+	text.SetSize(5, 5)
+	text.Replace("1.0", "end", "aaaaaaa\nb\nc\nd")
+	text.FoldAdd("1.0", "3.0")
+	strcmp(t, strings.Join(text.GetScreenLines(), "|"), "aaaaa|aa|d")
+	intcmp(t, text.CountDisplayLines("1.0", "end"), 2)
+	poscmp(t, text.Index("@0,2"), 4, 0)
+	if top, bottom := text.YView(); top != 0 || bottom != 1 {
+		t.Errorf("YView() == %f, %f; want %f, %f", top, bottom, 0.0, 1.0)
+	}
+}
+
+func TestFoldProvider(t *testing.T) {
+	text := New()
+	text.Insert("end", "def f():\n    a = 1\n    if x:\n\tb\n\n    c\nd")
+	text.SetFoldProvider(IndentFolds(8))
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[{1.0 6.5} {3.0 4.2}]")
+	text.Delete("2.0", "3.0")
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[{1.0 5.5} {2.0 3.2}]")
+	text.Insert("end", "\n  e")
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[{1.0 5.5} {2.0 3.2} {6.0 7.3}]")
+
+	text.Replace("1.0", "end", "func f() {\n\tx := []int{\n\t\t1,\n\t}\n}")
+	text.SetFoldProvider(BracketFolds())
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[{1.0 5.1} {2.0 4.2}]")
+	text.Insert("5.1", " else {\n}")
+	strcmp(t, fmt.Sprint(text.FoldRegions()),
+		"[{1.0 5.8} {2.0 4.2} {5.0 6.1}]")
+	text.Delete("2.end -1c", "3.0")
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[{1.0 3.2} {4.0 5.1}]")
+
+	// Lines are rescanned only until their states are unchanged
+	p := &countingFolds{FoldProvider: IndentFolds(4)}
+	text.Replace("1.0", "end", strings.Repeat("a\n    b\n", 100)+"c")
+	text.SetFoldProvider(p)
+	p.n = 0
+	text.Insert("50.0", "    x\n")
+	intcmp(t, p.n, 3)
+	regions := fmt.Sprint(text.FoldRegions())
+	text.SetFoldProvider(IndentFolds(4))
+	strcmp(t, fmt.Sprint(text.FoldRegions()), regions)
+	text.Delete("9.end", "60.0")
+	strcmp(t, fmt.Sprint(text.FoldRegions()[4:6]), "[{9.0 10.5} {11.0 12.5}]")
+
+	text.SetFoldProvider(nil)
+	strcmp(t, fmt.Sprint(text.FoldRegions()), "[]")
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("zero tab stop width did not cause panic")
+		}
+	}()
+	IndentFolds(0)
+}
+
+// Counts the lines scanned by a fold provider.
+type countingFolds struct {
+	FoldProvider
+	n int
+}
+
+func (p *countingFolds) ScanLine(line string, state interface{}) ([][2]int,
+	interface{}) {
+	p.n++
+	return p.FoldProvider.ScanLine(line, state)
+}
+
+// Tokenizes double-quoted strings and multi-line /* */ comments.
+type testTokenizer struct{}
+
+func (testTokenizer) Tokenize(line string,
+	state interface{}) ([]Token, interface{}) {
+	var tokens []Token
+	inComment := state == true
+	for i := 0; i < len(line); {
+		start := i
+		if inComment {
+			if j := strings.Index(line[i:], "*/"); j >= 0 {
+				i += j + 2
+				inComment = false
+			} else {
+				i = len(line)
+			}
+			tokens = append(tokens, Token{start, i, "comment"})
+		} else if strings.HasPrefix(line[i:], "/*") {
+			i += 2
+			inComment = true
+		} else if line[i] == '"' {
+			if j := strings.IndexByte(line[i+1:], '"'); j >= 0 {
+				i += j + 2
+			} else {
+				i = len(line)
+			}
+			tokens = append(tokens, Token{start, i, "string"})
+		} else {
+			i++
+		}
+	}
+	return tokens, inComment
+}
+
+func TestMatchBracket(t *testing.T) {
+	text := New()
+	text.Insert("end", "f(a, \"(\", b) {\n  /* } (\n */ x[1]\n}")
+	if _, ok := text.MatchBracket("1.1"); ok {
+		t.Error("MatchBracket matched bracket in string without tokenizer")
+	}
+	pos, _ := text.MatchBracket("1.13")
+	poscmp(t, pos, 2, 5)
+
+	text.SetTokenizer(testTokenizer{})
+	pos, _ = text.MatchBracket("1.1")
+	poscmp(t, pos, 1, 11)
+	pos, _ = text.MatchBracket("3.7")
+	poscmp(t, pos, 3, 5)
+	poscmp(t, text.Index("1.13 matchbracket"), 4, 0)
+	poscmp(t, text.Index("4.0 matchbracket"), 1, 13)
+	poscmp(t, text.Index("1.0 matchbracket"), 1, 0)
+	if _, ok := text.MatchBracket("1.6"); ok {
+		t.Error("MatchBracket matched bracket in string outside string")
+	}
+
+	text.SetBracketPairs("<>")
+	text.Replace("1.0", "end", "<a<b>>")
+	poscmp(t, text.Index("1.0 matchbracket"), 1, 5)
+	poscmp(t, text.Index("1.4 matchbracket"), 1, 2)
+
+	// Pairs of the same character cannot be matched
+	for _, pair := range []string{"||", "(", "(]>"} {
+		func() {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("bracket pair %q did not cause panic", pair)
+				}
+			}()
+			text.SetBracketPairs(pair)
+		}()
+	}
+	poscmp(t, text.Index("1.0 matchbracket"), 1, 5)
+}
+
+// Counts the lines lexed by a tokenizer.
+type countingTokenizer struct {
+	Tokenizer
+	n *int
+}
+
+func (c countingTokenizer) Tokenize(line string,
+	state interface{}) ([]Token, interface{}) {
+	*c.n++
+	return c.Tokenizer.Tokenize(line, state)
+}
+
+func TestHighlight(t *testing.T) {
+	for _, spec := range []string{"", "x y", "[a]\n( x", "[a]\nx k >b",
+		"[a]\nx k <>", "[a]\n[a]"} {
+		if _, err := ParseLexer(spec); err == nil {
+			t.Errorf("ParseLexer(%#v) returned nil error", spec)
+		}
+	}
+	lexer, err := ParseLexer(`
+# Test language
+[code]
+"([^"\\]|\\.)*"   string
+/\*               comment  >comment
+\b(if|else)\b     keyword
+[comment]
+\*/               comment  <
+.                 comment
+`)
+	if err != nil {
+		t.Fatalf("ParseLexer returned %v", err)
+	}
+
+	text := New()
+	text.SetSize(20, 5)
+	text.Insert("end", "if x \"s\" /* c\nstill */ else\ny")
+	n := 0
+	text.SetTokenizer(countingTokenizer{lexer, &n})
+	intcmp(t, n, 3)
+	want := "[[{0 2 keyword} {5 8 string} {9 13 comment}] " +
+		"[{0 8 comment} {9 13 keyword}] []]"
+	strcmp(t, fmt.Sprint(text.GetScreenStyles()), want)
+
+	n = 0
+	text.Insert("3.0", "z")
+	intcmp(t, n, 1)
+	n = 0
+	text.Insert("1.0", "x")
+	intcmp(t, n, 1)
+	n = 0
+	text.Delete("1.10", "1.12")
+	intcmp(t, n, 2)
+	want = "[[{6 9 string}] [{9 13 keyword}] []]" // "xif" is not a keyword
+	strcmp(t, fmt.Sprint(text.GetScreenStyles()), want)
+
+	// Rules are matched in the context of the whole line
+	tokens, _ := lexer.Tokenize("elif x", nil)
+	strcmp(t, fmt.Sprint(tokens), "[]")
+	anchored, _ := ParseLexer("[a]\n^x  start\nx\\b  end")
+	tokens, _ = anchored.Tokenize("xxyx", nil)
+	strcmp(t, fmt.Sprint(tokens), "[{0 1 start} {3 4 end}]")
+
+	text.SetWrap(Char)
+	text.SetSize(4, 5)
+	text.Replace("1.0", "end", "if if if")
+	want = "[[{0 2 keyword} {3 4 keyword}] [{0 1 keyword} {2 4 keyword}]]"
+	strcmp(t, fmt.Sprint(text.GetScreenStyles()), want)
+	text.SetTokenizer(nil)
+	strcmp(t, fmt.Sprint(text.GetScreenStyles()), "[[] []]")
+
+	// States need not be comparable with ==
+	text.Replace("1.0", "end", "a{\nb\nc}\nd")
+	n = 0
+	text.SetTokenizer(braceTokenizer{&n})
+	intcmp(t, n, 4)
+	n = 0
+	text.Insert("2.0", "x")
+	intcmp(t, n, 1)
+	text.Insert("2.0", "{")
+	intcmp(t, n, 4)
+}
+
+// Tokenizes nothing, carrying the unclosed braces as a slice.
+type braceTokenizer struct {
+	n *int
+}
+
+func (b braceTokenizer) Tokenize(line string, state interface{}) ([]Token,
+	interface{}) {
+	*b.n++
+	stack, _ := state.([]rune)
+	for _, ch := range line {
+		if n := len(stack); ch == '{' {
+			stack = append(stack[:n:n], ch)
+		} else if ch == '}' && n > 0 {
+			stack = stack[:n-1]
+		}
+	}
+	return nil, stack
+}
+
+// A virtual terminal that understands the escape sequences written by
+// Renderer. Each cell records its text and the parameters of the SGR sequence
+// in effect when it was written. Runes are as wide as cellWidth says.
+type vterm struct {
+	cells  [][]string
+	styles [][]string
+	x, y   int
+	sgr    string
+	cursor bool
+}
+
+func newVterm(width, height int) *vterm {
+	v := &vterm{}
+	v.cells = make([][]string, height)
+	v.styles = make([][]string, height)
+	for i := range v.cells {
+		v.cells[i] = strings.Split(strings.Repeat(" ", width), "")
+		v.styles[i] = make([]string, width)
+	}
+	return v
+}
+
+func (v *vterm) Write(p []byte) (int, error) {
+	s := string(p)
+	for len(s) > 0 {
+		if strings.HasPrefix(s, "\x1b[") {
+			end := strings.IndexAny(s[2:], "HJmhl") + 2
+			params, final := s[2:end], s[end]
+			s = s[end+1:]
+			switch final {
+			case 'H':
+				fmt.Sscanf(params, "%d;%d", &v.y, &v.x)
+				v.x--
+				v.y--
+			case 'J':
+				*v = *newVterm(len(v.cells[0]), len(v.cells))
+			case 'm':
+				v.sgr = params
+			case 'h', 'l':
+				v.cursor = final == 'h'
+			}
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		width := cellWidth(r)
+		if width == 0 {
+			v.cells[v.y][v.x-1] += string(r)
+			continue
+		}
+		v.cells[v.y][v.x] = string(r)
+		v.styles[v.y][v.x] = v.sgr
+		for i := 1; i < width; i++ {
+			v.cells[v.y][v.x+i] = ""
+		}
+		v.x += width
+	}
+	return len(p), nil
+}
+
+func (v *vterm) String() string {
+	rows := make([]string, len(v.cells))
+	for i, row := range v.cells {
+		rows[i] = strings.TrimRight(strings.Join(row, ""), " ")
+	}
+	return strings.Join(rows, "|")
+}
+
+// Return the number of terminal columns covered by a rune: two for Han
+// characters, none for combining marks, and one for the rest
+func cellWidth(r rune) int {
+	if unicode.Is(unicode.Han, r) {
+		return 2
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return 0
+	}
+	return 1
+}
+
+// Measures runes in terminal columns.
+type cellWidthMeasurer struct{}
+
+func (cellWidthMeasurer) RuneWidth(r rune) int {
+	return cellWidth(r)
+}
+
+func (m cellWidthMeasurer) StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += cellWidth(r)
+	}
+	return width
+}
+
+func (cellWidthMeasurer) LineHeight() int {
+	return 1
+}
+
+// Records the number of bytes written to a writer.
+type countingWriter struct {
+	io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return w.Writer.Write(p)
+}
+
+func TestRenderer(t *testing.T) {
+	text := New()
+	text.SetSize(12, 3)
+	text.SetGutter(AbsoluteNumbers)
+	text.Insert("end", "hello\nworld\tx")
+	lexer, _ := ParseLexer("[code]\nhello keyword")
+	text.SetTokenizer(lexer)
+	text.SelectionSet("1.3", "1.1")
+
+	term := newVterm(12, 3)
+	w := &countingWriter{term, 0}
+	r := NewRenderer(w)
+	r.Styles = map[string]Style{"keyword": {Bold: true}}
+	r.Selection = Style{Reverse: true}
+	r.GutterStyle = Style{Fg: Palette(8)}
+	if err := r.Render(text); err != nil {
+		t.Fatalf("Render returned %v", err)
+	}
+	strcmp(t, term.String(), "1 hello|2 world   x|")
+	strcmp(t, term.styles[0][0], "0;38;5;8")
+	strcmp(t, term.styles[0][2], "0;1")
+	strcmp(t, term.styles[0][3], "0;1;7") // Selected keyword
+	strcmp(t, term.styles[0][5], "0;1")
+	strcmp(t, term.styles[1][2], "0")
+	if !term.cursor || term.x != 3 || term.y != 0 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 3,0", term.x, term.y,
+			term.cursor)
+	}
+
+	// Only changed cells are redrawn
+	w.n = 0
+	r.Render(text)
+	if w.n > 20 {
+		t.Errorf("unchanged render wrote %d bytes", w.n)
+	}
+	text.SelectionClear()
+	text.Replace("2.4", "2.5", "D")
+	text.MarkSet("insert", "1.0")
+	w.n = 0
+	r.Render(text)
+	strcmp(t, term.String(), "1 hello|2 worlD   x|")
+	strcmp(t, term.styles[0][3], "0;1")
+	if w.n > 60 {
+		t.Errorf("render of three changed cells wrote %d bytes", w.n)
+	}
+	if !term.cursor || term.x != 2 || term.y != 0 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 2,0", term.x, term.y,
+			term.cursor)
+	}
+	text.MarkSet("insert", "2.end")
+	text.SetSize(5, 3)
+	r.Render(text)
+	if term.cursor {
+		t.Error("cursor shown outside of view")
+	}
+	strcmp(t, term.String(), "1 hel|2 wor|")
+
+	// Wide runes cover two cells, and combining marks share a cell
+	text.SetGutter(NoGutter)
+	text.SetSize(6, 3)
+	text.SetMeasurer(cellWidthMeasurer{})
+	text.Replace("1.0", "1.end", "中a\u0301文b")
+	text.MarkSet("insert", "1.6")
+	r.Render(text)
+	strcmp(t, term.String(), "中a\u0301文b|worlD|")
+	if !term.cursor || term.x != 3 || term.y != 0 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 3,0", term.x, term.y,
+			term.cursor)
+	}
+	text.Replace("1.0", "1.3", "x")
+	r.Render(text)
+	strcmp(t, term.String(), "xa\u0301文b|worlD|")
+	if c := r.back[0][3]; c.Text != "" || c.Width != 0 {
+		t.Errorf("cell 3,0 is %+v, want a continuation cell", c)
+	}
+
+	// Views can also be drawn on a renderer as a Screen
+	view := &View{Text: text}
+	text.SetSize(3, 1)
+	r.SetSize(4, 1)
+	Draw(view, r)
+	strcmp(t, term.String(), "xa\u0301||")
+}
+
+func TestDraw(t *testing.T) {
+	text := New()
+	text.SetSize(6, 2)
+	text.Insert("end", "one\ntwo\nthree")
+	screen := NewMemScreen(8, 3)
+	for i := 0; i < 3; i++ {
+		screen.SetCell(i, 2, Cell{"x", 1, Style{}})
+	}
+	view := &View{Text: text, Selection: Style{Reverse: true}}
+	text.SelectionSet("1.1", "1.2")
+	if err := Draw(view, screen); err != nil {
+		t.Fatalf("Draw returned %v", err)
+	}
+	strcmp(t, screen.String(), "one\ntwo\n")
+	if c := screen.Cell(1, 0); c.Text != "n" || !c.Style.Reverse {
+		t.Errorf("cell 1,0 is %+v, want selected \"n\"", c)
+	}
+	if x, y, shown := screen.Cursor(); !shown || x != 2 || y != 0 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 2,0", x, y, shown)
+	}
+	intcmp(t, screen.Flushes(), 1)
+
+	// Cells are only displayed once flushed
+	screen.SetCell(0, 0, Cell{"O", 1, Style{}})
+	strcmp(t, screen.String(), "one\ntwo\n")
+	screen.Flush()
+	strcmp(t, screen.String(), "One\ntwo\n")
+
+	text.SetGutter(AbsoluteNumbers)
+	text.SetSize(8, 2)
+	text.MarkSet("insert", "3.0")
+	text.YViewScroll(1)
+	Draw(view, screen)
+	strcmp(t, screen.String(), "2 two\n3 three\n")
+	if x, y, shown := screen.Cursor(); !shown || x != 2 || y != 1 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 2,1", x, y, shown)
+	}
+	text.YViewScroll(-1)
+	Draw(view, screen)
+	if _, _, shown := screen.Cursor(); shown {
+		t.Error("cursor shown outside of view")
+	}
+
+	// Wide runes cut off by the edges of the display are left blank
+	text = New()
+	text.SetMeasurer(cellWidthMeasurer{})
+	text.SetSize(4, 1)
+	text.Insert("end", "文字文x")
+	view.Text = text
+	text.XViewScroll(1)
+	text.SelectionSet("1.0", "1.3")
+	Draw(view, screen)
+	strcmp(t, screen.String(), " 字\n\n")
+	if c := screen.Cell(0, 0); c.Text != " " || !c.Style.Reverse {
+		t.Errorf("cell 0,0 is %+v, want a selected blank", c)
+	}
+	if c := screen.Cell(2, 0); c.Text != "" || c.Width != 0 {
+		t.Errorf("cell 2,0 is %+v, want a continuation cell", c)
+	}
+}
+
+func TestGutter(t *testing.T) {
+	text := New()
+	text.SetSize(6, 4)
+	text.Insert("end", "l1\nl2\nl3\nl4\nl5\nl6\nl7\nl8\nl9")
+	text.SetGutter(AbsoluteNumbers)
+	intcmp(t, text.GutterWidth(), 2)
+	strcmp(t, fmt.Sprint(text.GetScreenGutter()), "[1  2  3  4 ]")
+	text.Insert("end", "\nl10")
+	intcmp(t, text.GutterWidth(), 3)
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 1 " " 2 " " 3 " " 4 "]`)
+
+	// Coordinates count the gutter
+	x, y := text.BBox("2.1")
+	intcmp(t, x, 4)
+	intcmp(t, y, 1)
+	x, y, w := text.DLineInfo("2.1")
+	intcmp(t, x, 4)
+	intcmp(t, w, 2)
+	poscmp(t, text.Index("@4,1"), 2, 1)
+	poscmp(t, text.Index("@0,1"), 2, 0)
+	s := text.Snapshot()
+	x, y = s.BBox("2.1")
+	intcmp(t, x, 4)
+	intcmp(t, s.GutterWidth(), 3)
+
+	// Relative numbers count visible lines
+	text.MarkSet("insert", "2.0")
+	text.SetGutter(RelativeNumbers)
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 1 " " 0 " " 1 " " 2 "]`)
+	text.SetGutter(HybridNumbers)
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 1 " " 2 " " 1 " " 2 "]`)
+	id := text.FoldAdd("3.0", "5.0")
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 1 " " 2 " " 1 " " 2 "]`)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[l1 l2 l3 l6]")
+	text.MarkSet("insert", "6.0")
+	text.SetGutter(RelativeNumbers)
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 3 " " 2 " " 1 " " 0 "]`)
+	text.FoldRemove(id)
+
+	// Continuation rows of wrapped lines are marked
+	text.SetGutter(AbsoluteNumbers)
+	text.SetWrap(Char)
+	text.Replace("1.0", "1.end", "abcdefghij")
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenGutter()),
+		`[" 1 " " ↪ " " ↪ " " ↪ "]`)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[abc def ghi j]")
+	x, y = text.BBox("1.4")
+	intcmp(t, x, 4)
+	intcmp(t, y, 1)
+	poscmp(t, text.Index("@5,2"), 1, 8)
+
+	// See keeps the index within the text display
+	text.SetWrap(None)
+	text.See("1.9")
+	if x, _ := text.BBox("1.9"); x < 3 || x >= 6 {
+		t.Errorf("See left 1.9 at column %d", x)
+	}
+	text.See("10.0")
+	if _, y := text.BBox("10.0"); y < 0 || y >= 4 {
+		t.Errorf("See left 10.0 at row %d", y)
+	}
+
+	text.SetGutter(NoGutter)
+	if text.GetScreenGutter() != nil {
+		t.Error("gutter returned with no gutter set")
+	}
+	text.XViewMoveTo(0)
+	text.YViewMoveTo(0)
+	strcmp(t, text.GetScreenLines()[0], "abcdef")
+}
+
+func TestDamage(t *testing.T) {
+	text := New()
+	text.SetSize(10, 4)
+	text.Insert("end", "a\nb\nc\nd\ne\nf")
+	damaged := func(want string) {
+		d := text.Damage()
+		got := fmt.Sprint(d.Rows)
+		if d.Full {
+			got = "full"
+		}
+		strcmp(t, got, want)
+	}
+	damaged("full")
+	damaged("[]")
+
+	// Edits damage their lines, and the lines after them if lines are
+	// added or removed
+	text.Replace("2.0", "2.1", "B")
+	damaged("[1]")
+	text.Insert("3.0", "x\n")
+	damaged("[2 3]")
+	text.Insert("6.0", "y")
+	damaged("[]")
+	text.Delete("3.0", "4.0")
+	text.Insert("1.1", "z")
+	damaged("[0 2 3]")
+	text.Delete("2.0", "end")
+	damaged("[1 2 3]")
+
+	// Changes to the view require a full redraw
+	text.Replace("1.0", "end", "abc\nd\ne\nf\ng")
+	text.YViewScroll(1)
+	damaged("full")
+	text.YViewScroll(-1)
+	text.SetWrap(Char)
+	damaged("full")
+	text.SetSize(3, 4)
+	damaged("full")
+
+	// Wrapped lines damage the lines after them if they change height
+	text.Insert("1.3", "x")
+	damaged("[0 1 2 3]")
+	text.Insert("2.1", "y")
+	damaged("[2]")
+	text.SetWrap(None)
+	damaged("full")
+
+	// Folds damage the lines from their headers onward
+	id := text.FoldAdd("2.0", "3.0")
+	damaged("[1 2 3]")
+	text.FoldRemove(id)
+	damaged("[1 2 3]")
+
+	// Highlighting damages the lines that are relexed
+	lexer, _ := ParseLexer("[code]\n/\\*  comment  >c\n[c]\n.  comment")
+	text.SetTokenizer(lexer)
+	damaged("full")
+	text.Insert("3.0", "/*")
+	damaged("[2 3]")
+
+	text.SetGutter(RelativeNumbers)
+	damaged("full")
+	text.MarkSet("insert", "2.0")
+	damaged("full")
+	text.SetGutter(AbsoluteNumbers)
+	text.Damage()
+	text.MarkSet("insert", "3.0")
+	damaged("[]")
+}
+
+// Measures 'i' as 1 unit wide, 'm' as 3, and other runes as 2.
+type testMeasurer struct{}
+
+func (testMeasurer) RuneWidth(r rune) int {
+	switch r {
+	case 'i':
+		return 1
+	case 'm':
+		return 3
+	}
+	return 2
+}
+
+func (m testMeasurer) StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += m.RuneWidth(r)
+	}
+	return width
+}
+
+func (testMeasurer) LineHeight() int {
+	return 10
+}
+
+func TestMinimap(t *testing.T) {
+	text := New()
+	lexer, err := ParseLexer(`
+[code]
+//.*            comment
+\b(if|else)\b   keyword
+`)
+	if err != nil {
+		t.Fatalf("ParseLexer returned %v", err)
+	}
+	text.SetTokenizer(lexer)
+	text.Insert("1.0", "if x\n\n  // ab\nelse\ny\n// c d e")
+	text.SetSize(10, 2)
+	check := func(m Minimap, want []MinimapRow) {
+		if len(m.Rows) != len(want) {
+			t.Fatalf("len(Rows) == %d; want %d", len(m.Rows), len(want))
+		}
+		for i, row := range m.Rows {
+			if row != want[i] {
+				t.Errorf("Rows[%d] == %v; want %v", i, row, want[i])
+			}
+		}
+	}
+
+	m := text.Minimap(3)
+	check(m, []MinimapRow{
+		{1, 2, 0.75, "keyword"},
+		{3, 4, 8.0 / 11, "comment"},
+		{5, 6, 6.0 / 9, "comment"},
+	})
+	if m.Top != 0 || m.Bottom != 2.0/6 || m.TopRow != 0 || m.BottomRow != 0 {
+		t.Errorf("band == %f, %f, %d, %d; want %f, %f, %d, %d", m.Top,
+			m.Bottom, m.TopRow, m.BottomRow, 0.0, 2.0/6, 0, 0)
+	}
+	intcmp(t, len(text.Minimap(10).Rows), 6)
+
+	// Edits recompute only the edited lines
+	text.Insert("5.0", "else else ")
+	if text.lines.entry(5).stat != nil || text.lines.entry(4).stat == nil {
+		t.Errorf("stats of lines 4 and 5 not updated after edit")
+	}
+	text.YViewScroll(3)
+	m = text.Minimap(3)
+	check(m, []MinimapRow{
+		{1, 2, 0.75, "keyword"},
+		{3, 4, 8.0 / 11, "comment"},
+		{5, 6, 14.0 / 19, "keyword"},
+	})
+	intcmp(t, m.TopRow, 1)
+	intcmp(t, m.BottomRow, 2)
+
+	text.SetTokenizer(nil)
+	strcmp(t, text.Minimap(1).Rows[0].Style, "")
+}
+
+// Check that the hunks describe the differences between two texts
+func checkHunks(t *testing.T, old, new []string, hunks []Hunk) {
+	i, j := 1, 1
+	gap := func(oldLine, newLine int) {
+		for ; i < oldLine; i, j = i+1, j+1 {
+			if j > len(new) || old[i-1] != new[j-1] {
+				t.Fatalf("line %d of old text does not match line %d", i, j)
+			}
+		}
+		if j != newLine {
+			t.Fatalf("hunk at line %d follows line %d", newLine, j)
+		}
+	}
+	for _, h := range hunks {
+		gap(h.OldLine, h.NewLine)
+		i, j = i+h.OldCount, j+h.NewCount
+	}
+	gap(len(old)+1, len(new)+1)
+}
+
+func TestDiff(t *testing.T) {
+	// Matches are valid and, for Myers' algorithm, as many as possible
+	lcs := func(a, b []int) int {
+		dp := make([][]int, len(a)+1)
+		for i := range dp {
+			dp[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					dp[i][j] = dp[i+1][j+1] + 1
+				} else {
+					dp[i][j] = maxInt(dp[i+1][j], dp[i][j+1])
+				}
+			}
+		}
+		return dp[0][0]
+	}
+	for n := 0; n < 500; n++ {
+		a, b := make([]int, rand.Int()%15), make([]int, rand.Int()%15)
+		for i := range a {
+			a[i] = rand.Int() % 4
+		}
+		for i := range b {
+			b[i] = rand.Int() % 4
+		}
+		for _, algo := range []DiffAlgorithm{Myers, Patience} {
+			m := matchSeqs(a, b, 0, 0, algo, nil)
+			for k, p := range m {
+				if a[p[0]] != b[p[1]] || k > 0 &&
+					(p[0] <= m[k-1][0] || p[1] <= m[k-1][1]) {
+					t.Fatalf("invalid matches %v of %v and %v", m, a, b)
+				}
+			}
+			if algo == Myers && len(m) != lcs(a, b) {
+				t.Errorf("%v and %v: %d matches; want %d", a, b, len(m),
+					lcs(a, b))
+			}
+		}
+	}
+
+	// Hunks
+	text := New()
+	text.Insert("1.0", "a\nb\nc\nd")
+	hunks := text.DiffString("a\nx\nc\nd\ne", DiffOptions{})
+	want := []Hunk{
+		{2, 1, 2, 1, Range{Position{2, 0}, Position{3, 0}},
+			Range{Position{2, 0}, Position{3, 0}}, nil},
+		{5, 1, 5, 0, Range{Position{5, 0}, Position{5, 1}},
+			Range{Position{4, 1}, Position{4, 1}}, nil},
+	}
+	strcmp(t, fmt.Sprint(hunks), fmt.Sprint(want))
+	if hunks[0].Kind() != Modified || hunks[1].Kind() != Deleted {
+		t.Errorf("Kind() == %v, %v; want %v, %v", hunks[0].Kind(),
+			hunks[1].Kind(), Modified, Deleted)
+	}
+	other := New()
+	other.Insert("1.0", "a\nx\nc\nd\ne")
+	strcmp(t, fmt.Sprint(text.Diff(other, DiffOptions{Algorithm: Patience})),
+		fmt.Sprint(want))
+
+	// Words
+	text.Replace("1.0", "end", "the quick brown fox")
+	hunks = text.DiffString("the slow brown dog", DiffOptions{Words: true})
+	strcmp(t, fmt.Sprint(hunks[0].Words), fmt.Sprint([]WordChange{
+		{Range{Position{1, 4}, Position{1, 8}},
+			Range{Position{1, 4}, Position{1, 9}}},
+		{Range{Position{1, 15}, Position{1, 18}},
+			Range{Position{1, 16}, Position{1, 19}}},
+	}))
+
+	// Markers
+	base := "one\ntwo\nthree\nfour\nfive"
+	text = New()
+	text.Insert("1.0", base)
+	text.EditReset()
+	text.SetGutter(AbsoluteNumbers)
+	text.SetSize(10, 10)
+	text.SetDiffBase(base, Myers)
+	intcmp(t, len(text.DiffBaseHunks()), 0)
+	text.Insert("2.0", "new\n")
+	text.Replace("4.0", "4.end", "THREE")
+	text.Delete("5.end", "6.end")
+	strcmp(t, strings.Join(text.GetScreenGutter(), "|"), "1 |2+|3 |4~|5_")
+	if got := text.LineChange("4.2"); got != Modified {
+		t.Errorf("LineChange(\"4.2\") == %v; want %v", got, Modified)
+	}
+	text.Delete("3.0", "4.0")
+	strcmp(t, strings.Join(text.GetScreenGutter(), "|"), "1 |2~|3~|4_")
+	for text.EditUndo() {
+	}
+	intcmp(t, len(text.DiffBaseHunks()), 0)
+	strcmp(t, strings.Join(text.GetScreenGutter(), "|"), "1 |2 |3 |4 |5 ")
+
+	// Markers stay consistent with the buffer through random edits
+	lines := strings.Split(base, "\n")
+	for _, algo := range []DiffAlgorithm{Myers, Patience} {
+		text.SetDiffBase(base, algo)
+		for n := 0; n < 300; n++ {
+			end := text.Index("end")
+			i := rand.Int()%end.Line + 1
+			j := i + rand.Int()%3
+			if rand.Int()%2 == 0 {
+				text.Delete(fmt.Sprintf("%d.0", i), fmt.Sprintf("%d.0", j))
+			} else {
+				text.Insert(fmt.Sprintf("%d.0", i),
+					strings.Repeat(lines[rand.Int()%len(lines)]+"\n", j-i))
+			}
+			checkHunks(t, lines, strings.Split(text.Get("1.0", "end"), "\n"),
+				text.DiffBaseHunks())
+		}
+	}
+	text.ClearDiffBase()
+	if text.DiffBaseHunks() != nil {
+		t.Errorf("DiffBaseHunks() != nil after ClearDiffBase")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	// Parse errors
+	for _, patch := range []string{
+		"@@ -1,2 +1,2 @@\n a\n",
+		"@@ -1,1 +1,1 @@\n*a\n",
+		"--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n--- a/y\n+++ b/y\n",
+	} {
+		if _, err := ParsePatch(patch); err == nil {
+			t.Errorf("ParsePatch(%#v) returned nil error", patch)
+		}
+	}
+
+	patch := `--- a/file
++++ b/file
+@@ -1,4 +1,4 @@
+ a
+-b
++B
+ c
+ d
+@@ -7,3 +7,4 @@
+ g
+ h
++h2
+ i
+`
+	text := New()
+	text.Insert("1.0", "x\ny\na\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	text.EditReset()
+	text.MarkSet("m", "12.0")
+
+	// Offsets
+	result, err := text.ApplyPatch(patch, PatchOptions{})
+	if err != nil {
+		t.Fatalf("ApplyPatch returned %v", err)
+	}
+	intcmp(t, len(result.Applied), 0)
+	intcmp(t, len(result.Failed), 2)
+	result, _ = text.ApplyPatch(patch, PatchOptions{MaxOffset: 2})
+	intcmp(t, len(result.Failed), 0)
+	if len(result.Applied) == 2 {
+		intcmp(t, result.Applied[0].Line, 3)
+		intcmp(t, result.Applied[0].Offset, 2)
+		intcmp(t, result.Applied[1].Line, 9)
+		intcmp(t, result.Applied[1].Offset, 2)
+	}
+	strcmp(t, text.Get("1.0", "end"),
+		"x\ny\na\nB\nc\nd\ne\nf\ng\nh\nh2\ni\nj\n")
+	poscmp(t, text.Index("m"), 13, 0)
+
+	// One undoable change
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"),
+		"x\ny\na\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	if text.EditUndo() {
+		t.Errorf("EditUndo() == true after undoing patch")
+	}
+
+	// Fuzz and failed hunks
+	text.Replace("6.0", "6.end", "D")
+	text.Replace("10.0", "10.end", "H")
+	result, _ = text.ApplyPatch(patch, PatchOptions{Fuzz: 1, MaxOffset: 2})
+	if len(result.Applied) != 1 || len(result.Failed) != 1 {
+		t.Fatalf("applied %d and failed %d hunks; want 1 and 1",
+			len(result.Applied), len(result.Failed))
+	}
+	intcmp(t, result.Applied[0].Fuzz, 1)
+	intcmp(t, result.Failed[0].OldLine, 7)
+	strcmp(t, text.Get("3.0", "6.end"), "a\nB\nc\nD")
+
+	// Missing line breaks
+	text.Replace("1.0", "end", "a\nb")
+	result, _ = text.ApplyPatch(`@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++c
+`, PatchOptions{})
+	intcmp(t, len(result.Applied), 1)
+	strcmp(t, text.Get("1.0", "end"), "a\nc\n")
+}
+
+func TestMeasurer(t *testing.T) {
+	text := New()
+	text.SetMeasurer(testMeasurer{})
+	text.SetSize(10, 30)
+	text.Insert("end", "mim im\nabc")
+	x, _ := text.BBox("1.5")
+	intcmp(t, x, 10)
+	_, _, w := text.DLineInfo("1.0")
+	intcmp(t, w, 13)
+	poscmp(t, text.Index("@3,0"), 1, 1)
+	poscmp(t, text.Index("@4,0"), 1, 2)
+	poscmp(t, text.Index("@20,0"), 1, 6)
+	intcmp(t, text.CountXPixels("1.0", "1.3"), 7)
+	intcmp(t, text.CountYPixels("1.0", "2.0"), 10)
+
+	// Horizontal scrolling is in pixels
+	text.XViewScroll(4)
+	strcmp(t, text.GetScreenLines()[0], "im im")
+	x, _ = text.BBox("1.1")
+	intcmp(t, x, 0)
+	text.XViewMoveTo(0)
+
+	// Wrapping is by pixel width
+	text.SetWrap(Char)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[mim i m abc]")
+	x, y := text.BBox("1.5")
+	intcmp(t, x, 0)
+	intcmp(t, y, 10)
+	text.SetWrap(Word)
+	strcmp(t, fmt.Sprintf("%q", text.GetScreenLines()),
+		`["mim " "im" "abc"]`)
+	x, y, w = text.DLineInfo("1.5")
+	intcmp(t, x, 1)
+	intcmp(t, y, 10)
+	intcmp(t, w, 4)
+	poscmp(t, text.Index("@1,10"), 1, 5)
+	poscmp(t, text.Index("@9,19"), 1, 6)
+	poscmp(t, text.Index("@9,0"), 1, 3)
+	intcmp(t, text.CountYPixels("1.0", "2.0"), 20)
+
+	text.SetGutter(AbsoluteNumbers)
+	intcmp(t, text.GutterWidth(), 4)
+	text.SetMeasurer(nil)
+	intcmp(t, text.GutterWidth(), 2)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[mim im abc]")
+
+	// Whitespace at the end of a word-wrapped line overhangs the display
+	text.SetGutter(NoGutter)
+	text.SetSize(5, 30)
+	text.Replace("1.0", "end", "ab cd ef")
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[ab cd ef]")
+	poscmp(t, text.Index("@1,1"), 1, 7)
+}
+
+func TestLineSpacing(t *testing.T) {
+	text := New()
+	text.SetSize(4, 10)
+	text.Insert("end", "a\nbbbbbb\nc\nd\ne")
+	text.SetLineSpacing(LineSpacing{Above: 1, Below: 2})
+	_, y := text.BBox("2.0")
+	intcmp(t, y, 5)
+	_, y, _ = text.DLineInfo("2.0")
+	intcmp(t, y, 4)
+	intcmp(t, text.DLineHeight("2.0"), 4)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[a bbbb c]")
+	poscmp(t, text.Index("@0,4"), 2, 0)
+	poscmp(t, text.Index("@0,9"), 3, 0)
+	intcmp(t, text.CountYPixels("1.0", "3.0"), 8)
+	top, bottom := text.YView()
+	if top != 0 || bottom != 0.5 {
+		t.Errorf("YView() == %f, %f; want 0, 0.5", top, bottom)
+	}
+	text.YViewScroll(10)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[d e]")
+	text.YViewScroll(-10)
+
+	// Ranges of lines can have their own spacing
+	id := text.LineSpacingAdd("2.0", "2.0", LineSpacing{Height: 3})
+	_, y = text.BBox("3.0")
+	intcmp(t, y, 8)
+	intcmp(t, text.DLineHeight("2.0"), 3)
+	poscmp(t, text.Index("@0,6"), 2, 0)
+	poscmp(t, text.Index("@0,7"), 3, 0)
+	strcmp(t, fmt.Sprint(text.GetScreenLines()), "[a bbbb c]")
+	text.LineSpacingRemove(id)
+
+	// Spacing between the display lines of a wrapped line
+	text.SetWrap(Char)
+	text.SetLineSpacing(LineSpacing{Between: 1})
+	_, y = text.BBox("2.5")
+	intcmp(t, y, 3)
+	_, y, _ = text.DLineInfo("2.5")
+	intcmp(t, y, 2)
+	intcmp(t, text.DLineHeight("2.5"), 2)
+	text.SetSize(4, 3)
+	text.See("5.0")
+	if _, y := text.BBox("5.0"); y < 0 || y >= 3 {
+		t.Errorf("See left 5.0 at y %d", y)
+	}
+
+	// Ranges follow edits
+	text.SetWrap(None)
+	text.SetLineSpacing(LineSpacing{})
+	id = text.LineSpacingAdd("3.0", "3.0", LineSpacing{Height: 2})
+	text.Insert("1.0", "x\n")
+	intcmp(t, text.DLineHeight("3.0"), 1)
+	intcmp(t, text.DLineHeight("4.0"), 2)
+	text.LineSpacingRemove(id)
+	intcmp(t, text.DLineHeight("4.0"), 1)
+
+	// Offsets inside taller lines resolve to those lines
+	text = New()
+	text.SetSize(4, 10)
+	text.Insert("1.0", "a\nb\nc\nd\ne")
+	id = text.LineSpacingAdd("2.0", "2.0", LineSpacing{Height: 5})
+	for y, line := range []int{1, 2, 2, 2, 2, 2, 3, 4} {
+		poscmp(t, text.Index(fmt.Sprintf("@0,%d", y)), line, 0)
+	}
+	text.SetSize(4, 3)
+	text.YViewScrollBy(3, Pixels)
+	strcmp(t, text.GetScreenLines()[0], "b")
+	text.YViewMoveTo(0)
+	text.SetSize(4, 10)
+	text.SetLineSpacing(LineSpacing{Above: 2})
+	for y, line := range []int{1, 1, 1, 2, 2, 2, 2, 2, 3, 3, 3, 4} {
+		poscmp(t, text.Index(fmt.Sprintf("@0,%d", y)), line, 0)
+	}
+	text.LineSpacingRemove(id)
+	text.SetLineSpacing(LineSpacing{Between: 1})
+	text.SetWrap(Char)
+	text.Replace("2.0", "2.end", "bbbbbbbbb")
+	for y, char := range []int{0, 4, 4, 8, 8} {
+		poscmp(t, text.Index(fmt.Sprintf("@0,%d", y+1)), 2, char)
+	}
+	poscmp(t, text.Index("@0,6"), 3, 0)
+
+	// Lines are drawn and damaged at their spaced offsets
+	text = New()
+	text.SetSize(10, 6)
+	text.Insert("end", "aa\nbb\ncc")
+	text.SetLineSpacing(LineSpacing{Above: 1})
+	text.MarkSet("insert", "2.1")
+	screen := NewMemScreen(10, 6)
+	Draw(&View{Text: text}, screen)
+	strcmp(t, screen.String(), "\naa\n\nbb\n\ncc")
+	if x, y, shown := screen.Cursor(); !shown || x != 1 || y != 3 {
+		t.Errorf("cursor at %d,%d (shown: %v), want 1,3", x, y, shown)
+	}
+	text.Damage()
+	text.Delete("2.0", "end")
+	strcmp(t, fmt.Sprint(text.Damage().Rows), "[1 2]")
+}
+
+func TestScroll(t *testing.T) {
+	text := New()
+	text.SetSize(5, 10)
+	for i := 1; i <= 30; i++ {
+		text.Insert("end", fmt.Sprintf("l%d\n", i))
+	}
+	text.Delete("end -1c", "end")
+	top := func(want string) {
+		strcmp(t, text.GetScreenLines()[0], want)
+	}
+	text.YViewScrollBy(1, Pages)
+	top("l9")
+	text.YViewScrollBy(-1, Pages)
+	top("l1")
+	text.YViewScrollBy(2, Units)
+	top("l3")
+	text.YViewScrollBy(100, Units)
+	top("l21")
+	text.YViewScrollBy(-100, Pixels)
+	top("l1")
+
+	// Scanning
+	text.Replace("1.0", "1.end", "abcdefghijklmnop")
+	text.ScanMark(10, 5)
+	text.ScanDragTo(10, 3, 1)
+	top("l3")
+	text.ScanDragTo(10, 4, 10)
+	top("l11")
+	text.ScanDragTo(10, 9, 1)
+	top("abcde")
+	text.ScanDragTo(7, 5, 2)
+	top("ghijk")
+	text.XViewScrollBy(1, Pages)
+	top("jklmn")
+	text.XViewScrollBy(-2, Units)
+	top("hijkl")
+	text.XViewMoveTo(0)
+
+	// Smooth scrolling by pixels
+	text.SetMeasurer(testMeasurer{})
+	text.SetSize(50, 100)
+	text.YViewScrollBy(15, Pixels)
+	top("l2")
+	intcmp(t, text.YViewOffset(), 0)
+	text.SetSmoothScroll(true)
+	text.YViewScrollBy(15, Pixels)
+	top("l3")
+	intcmp(t, text.YViewOffset(), 5)
+	_, y := text.BBox("3.0")
+	intcmp(t, y, -5)
+	poscmp(t, text.Index("@0,6"), 4, 0)
+	text.ScanMark(0, 0)
+	text.ScanDragTo(0, 3, 1)
+	intcmp(t, text.YViewOffset(), 2)
+	text.SetSmoothScroll(false)
+	intcmp(t, text.YViewOffset(), 0)
+}
+
+func TestViewport(t *testing.T) {
+	text := New()
+	text.SetSize(5, 10)
+	for i := 1; i <= 30; i++ {
+		text.Insert("end", fmt.Sprintf("l%d\n", i))
+	}
+	text.Delete("end -1c", "end")
+	top := func(want string) {
+		strcmp(t, text.GetScreenLines()[0], want)
+	}
+
+	// Clamping
+	text.YViewMoveTo(1)
+	top("l21")
+	text.SetSmoothScroll(true)
+	text.YViewMoveTo(2)
+	top("l21")
+	intcmp(t, text.YViewOffset(), 0)
+	text.SetSmoothScroll(false)
+	text.YViewMoveTo(-1)
+	top("l1")
+
+	// Scrolloff
+	text.YViewMoveTo(1)
+	text.SetScrollOff(2)
+	text.See("15.0")
+	top("l11")
+	text.See("19.0")
+	top("l12")
+	text.See("12.0")
+	top("l10")
+	text.See("1.0")
+	top("l1")
+	text.SetScrollOff(20)
+	text.See("30.0")
+	top("l21")
+	text.See("20.0")
+	top("l16")
+	text.SetScrollOff(0)
+
+	// Horizontal scrolling and wrapping
+	text.Replace("1.0", "1.end", "abcdefghijklmnop")
+	text.See("1.end")
+	if left, _ := text.XView(); left != 11.0/16 {
+		t.Errorf("XView() == %f; want %f", left, 11.0/16)
+	}
+	text.SetWrap(Char)
+	if left, _ := text.XView(); left != 0 {
+		t.Errorf("XView() == %f; want %f", left, 0.0)
+	}
+	text.SetSize(5, 2)
+	text.See("1.12")
+	top("fghij")
+	text.See("1.0")
+	top("abcde")
+
+	// A buffer shorter than the display
+	text = New()
+	text.Insert("end", "a\nb")
+	text.SetSize(5, 10)
+	text.YViewScroll(1)
+	text.YViewScrollBy(1, Pages)
+	if top, bot := text.YView(); top != 0 || bot != 1 {
+		t.Errorf("YView() == %f, %f; want %f, %f", top, bot, 0.0, 1.0)
+	}
+
+	// Changes to the text and its layout keep the display filled
+	text = New()
+	text.SetSize(5, 4)
+	for i := 1; i <= 50; i++ {
+		text.Insert("end", fmt.Sprintf("l%d\n", i))
+	}
+	text.Delete("end -1c", "end")
+	text.YViewMoveTo(1)
+	top("l47")
+	text.Delete("40.0", "end")
+	top("l37")
+	text.FoldAdd("30.0", "39.0")
+	top("l28")
+	text.SetLineSpacing(LineSpacing{Above: 1})
+	text.YViewMoveTo(1)
+	top("l30")
+	text.SetLineSpacing(LineSpacing{})
+	top("l28")
+	text.Transaction(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			tx.Delete("1.0", "2.0")
+		}
+		return nil
+	})
+	top("l28")
+	text.Replace("1.0", "1.end", "abcdefghij")
+	text.XViewScroll(5)
+	text.Delete("1.5", "1.end")
+	if left, _ := text.XView(); left != 0 {
+		t.Errorf("XView() == %f; want %f", left, 0.0)
+	}
+	text.Delete("1.0", "end")
+	top("")
+	if top, bot := text.YView(); top != 0 || bot != 1 {
+		t.Errorf("YView() == %f, %f; want %f, %f", top, bot, 0.0, 1.0)
+	}
+}
+
 func TestIndex(t *testing.T) {
 	text := New()
 	text.Insert("1.0", "hello\nworld")
@@ -261,6 +1712,32 @@ func TestIndex(t *testing.T) {
 	poscmp(t, text.Index("@2,5"), 1, 3)
 }
 
+func TestWordChars(t *testing.T) {
+	text := New()
+	text.Insert("1.0", "(defun foo-bar) $var  naïve")
+
+	// Punctuation and whitespace runs
+	poscmp(t, text.Index("1.0 wordend"), 1, 1)
+	poscmp(t, text.Index("1.14 wordend"), 1, 15)
+	poscmp(t, text.Index("1.16 wordend"), 1, 17)
+	poscmp(t, text.Index("1.21 wordstart"), 1, 20)
+	poscmp(t, text.Index("1.22 wordstart"), 1, 22)
+	poscmp(t, text.Index("1.20 wordstart"), 1, 17)
+
+	// Unicode letters
+	poscmp(t, text.Index("1.22 wordend"), 1, 28)
+	poscmp(t, text.Index("1.28 wordstart"), 1, 22)
+
+	// Custom predicate
+	poscmp(t, text.Index("1.11 wordstart"), 1, 11)
+	text.SetWordChars(WordChars("-$", unicode.Letter))
+	poscmp(t, text.Index("1.11 wordstart"), 1, 7)
+	poscmp(t, text.Index("1.10 wordend"), 1, 14)
+	poscmp(t, text.Index("1.16 wordend"), 1, 20)
+	text.SetWordChars(nil)
+	poscmp(t, text.Index("1.16 wordend"), 1, 17)
+}
+
 func TestGet(t *testing.T) {
 	text := New()
 	text.Insert("1.0", "hello")
@@ -454,6 +1931,223 @@ func TestMarkUnset(t *testing.T) {
 	}
 }
 
+func TestSelection(t *testing.T) {
+	text := New()
+	text.Insert("1.0", "hello world\nfoo-bar baz\nqux")
+	if len(text.SelectionRanges()) != 0 {
+		t.Error("SelectionRanges returned non-empty slice for new TkText")
+	}
+	if text.SelectionDelete() {
+		t.Error("SelectionDelete returned true for empty selection")
+	}
+
+	text.SelectionSet("2.3", "1.6")
+	strcmp(t, text.SelectionGet(), "world\nfoo")
+	poscmp(t, text.Index("sel.first"), 1, 6)
+	poscmp(t, text.Index("sel.last -1c"), 2, 2)
+	text.Insert("1.0", ">> ")
+	strcmp(t, text.SelectionGet(), "world\nfoo")
+
+	// Granularity
+	text.SelectionExtend("1.5", SelectWord)
+	strcmp(t, text.SelectionGet(), "hello world\nfoo")
+	text.SelectionSet("2.5", "2.5")
+	strcmp(t, text.SelectionGet(), "")
+	text.SelectionExtend("2.5", SelectWord)
+	strcmp(t, text.SelectionGet(), "bar")
+	text.SelectionExtend("1.4", SelectLine)
+	strcmp(t, text.SelectionGet(), ">> hello world\nfoo-bar baz\n")
+	text.SelectionExtend("3.1", SelectLine)
+	strcmp(t, text.SelectionGet(), "foo-bar baz\nqux")
+
+	if !text.SelectionDelete() {
+		t.Error("SelectionDelete returned false for non-empty selection")
+	}
+	strcmp(t, text.Get("1.0", "end"), ">> hello world\n")
+	if len(text.SelectionRanges()) != 0 {
+		t.Error("SelectionRanges returned non-empty slice after delete")
+	}
+	text.SelectionSet("1.0", "1.2")
+	text.SelectionClear()
+	strcmp(t, text.SelectionGet(), "")
+	poscmp(t, text.Index("insert"), 1, 2)
+}
+
+func TestCursors(t *testing.T) {
+	text := New()
+	text.Insert("1.0", "one\ntwo\nthree")
+	text.CursorAdd("1.0", "1.0")
+	text.CursorAdd("2.0", "2.0")
+	text.CursorAdd("3.0", "3.0")
+	text.CursorAdd("3.0", "3.0")
+	if n := len(text.Cursors()); n != 3 {
+		t.Errorf("got %d cursors, want %d", n, 3)
+	}
+
+	text.EditSeparator()
+	text.CursorInsert("- ")
+	strcmp(t, text.Get("1.0", "end"), "- one\n- two\n- three")
+	poscmp(t, text.Cursors()[2], 3, 2)
+	text.CursorMove(" lineend", true)
+	strcmp(t, text.SelectionGet(), "one\ntwo\nthree")
+	text.CursorInsert("x\ny")
+	strcmp(t, text.Get("1.0", "end"), "- x\ny\n- x\ny\n- x\ny")
+	text.CursorDelete("-1c")
+	text.CursorDelete("-1c")
+	strcmp(t, text.Get("1.0", "end"), "- x\n- x\n- x")
+	text.EditUndo()
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "- x\ny\n- x\ny\n- x\ny")
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "- one\n- two\n- three")
+
+	// Merging
+	text.CursorClear()
+	if n := len(text.Cursors()); n != 1 {
+		t.Errorf("got %d cursors, want %d", n, 1)
+	}
+	text.SelectionSet("1.1", "1.1")
+	text.CursorAdd("1.0", "1.0")
+	text.CursorAdd("2.0", "2.0")
+	text.CursorMove("-1c", false)
+	if n := len(text.Cursors()); n != 2 {
+		t.Errorf("got %d cursors, want %d", n, 2)
+	}
+	text.CursorMove(" linestart", false)
+	if n := len(text.Cursors()); n != 1 {
+		t.Errorf("got %d cursors, want %d", n, 1)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	text := New()
+	changes := 0
+	text.OnChange(func() { changes++ })
+	text.Insert("1.0", "hello world")
+	intcmp(t, changes, 1)
+	text.MarkSet("m", "1.6")
+
+	err := text.Transaction(func(tx *Tx) error {
+		tx.Replace("1.0", "1.5", "goodbye")
+		tx.Insert("end", "\nagain")
+		strcmp(t, tx.Get("1.0", "1.end"), "goodbye world")
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Transaction returned %v", err)
+	}
+	intcmp(t, changes, 2)
+	strcmp(t, text.Get("1.0", "end"), "goodbye world\nagain")
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "hello world")
+	text.EditRedo()
+
+	want := errors.New("failed")
+	err = text.Transaction(func(tx *Tx) error {
+		tx.Delete("1.0", "end")
+		tx.Insert("1.0", "nothing")
+		return want
+	})
+	if err != want {
+		t.Errorf("Transaction returned %v, want %v", err, want)
+	}
+	intcmp(t, changes, 4)
+	strcmp(t, text.Get("1.0", "end"), "goodbye world\nagain")
+	poscmp(t, text.Index("m"), 1, 8)
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "hello world")
+	if !text.EditRedo() {
+		t.Error("EditRedo returned false after rolled back transaction")
+	}
+
+	// A panic rolls back the transaction and releases the lock
+	func() {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Error("Bad position in transaction did not cause panic")
+			}
+		}()
+		text.Transaction(func(tx *Tx) error {
+			tx.Insert("1.0", "x")
+			tx.Insert("bad", "y")
+			return nil
+		})
+	}()
+	intcmp(t, changes, 6)
+	strcmp(t, text.Get("1.0", "end"), "goodbye world\nagain")
+
+	// A rolled back transaction leaves no separator on the undo stack
+	text.EditReset()
+	text.Insert("end", "a")
+	text.Transaction(func(tx *Tx) error {
+		tx.Insert("end", "b")
+		return want
+	})
+	text.Insert("end", "c")
+	text.EditUndo()
+	strcmp(t, text.Get("1.0", "end"), "goodbye world\nagain")
+}
+
+func TestSnapshot(t *testing.T) {
+	text := New()
+	text.SetSize(10, 2)
+	text.Insert("end", "hello\nworld")
+	text.MarkSet("m", "2.2")
+	text.SelectionSet("1.1", "1.4")
+	snap := text.Snapshot()
+
+	text.Delete("1.0", "2.0")
+	text.Insert("1.0", "new ")
+	text.MarkSet("m", "1.0")
+	text.SelectionClear()
+
+	strcmp(t, snap.Get("1.0", "end"), "hello\nworld")
+	strcmp(t, text.Get("1.0", "end"), "new world")
+	poscmp(t, snap.Index("m"), 2, 2)
+	poscmp(t, text.Index("m"), 1, 0)
+	strcmp(t, snap.SelectionGet(), "ell")
+	intcmp(t, snap.CountLines("1.0", "end"), 1)
+	intcmp(t, snap.Compare("m", "1.0"), 1)
+	strcmp(t, strings.Join(snap.GetScreenLines(), "|"), "hello|world")
+}
+
+// Compare random splices of a lineStore against a plain slice of lines.
+func TestLineStore(t *testing.T) {
+	var model []string
+	store := newLineStore()
+	for n := 0; n < 2000; n++ {
+		i := rand.Int() % (len(model) + 1)
+		j := i + rand.Int()%(len(model)-i+1)%50
+		lines := make([]string, rand.Int()%300)
+		for k := range lines {
+			lines[k] = fmt.Sprint(n, ".", k)
+		}
+		prev, prevModel := store, append([]string(nil), model...)
+
+		store = store.splice(i, j, lines)
+		model = append(append(append([]string(nil), model[:i]...), lines...),
+			model[j:]...)
+		if store.Len() != len(model) {
+			t.Fatalf("got length %d, want %d", store.Len(), len(model))
+		}
+		store.each(1, func(n int, s string) bool {
+			strcmp(t, s, model[n-1])
+			return true
+		})
+		for k, s := range prevModel {
+			strcmp(t, prev.line(k+1), s)
+		}
+		for _, chunk := range store.chunks {
+			if len(chunk) == 0 || len(chunk) > chunkSize {
+				t.Fatalf("got chunk of size %d", len(chunk))
+			}
+		}
+		if len(model) > 10000 {
+			store, model = newLineStore(), nil
+		}
+	}
+}
+
 func TestUndo(t *testing.T) {
 	text := New()
 	text.EditSeparator()
@@ -550,8 +2244,8 @@ func TestSee(t *testing.T) {
 	if left, right := text.XView(); left != 0 || right != 0.2 {
 		t.Errorf("XView() == %f, %f; want %f, %f", left, right, 0.0, 0, 2)
 	}
-	if top, bot := text.YView(); top != 0.75 || bot != 1 {
-		t.Errorf("YView() == %f, %f; want %f, %f", top, bot, 0.75, 1.0)
+	if top, bot := text.YView(); top != 0.625 || bot != 1 {
+		t.Errorf("YView() == %f, %f; want %f, %f", top, bot, 0.625, 1.0)
 	}
 
 	text.See("1.0")
@@ -596,8 +2290,8 @@ func TestXView(t *testing.T) {
 	text.Replace("1.0", "end", "rhinoceros")
 	text.SetWrap(None)
 	text.XViewMoveTo(1)
-	if left, right := text.XView(); left != 1 || right != 1 {
-		t.Errorf("XView() == %f, %f; want %f, %f", left, right, 1.0, 1.0)
+	if left, right := text.XView(); left != 0.1 || right != 1 {
+		t.Errorf("XView() == %f, %f; want %f, %f", left, right, 0.1, 1.0)
 	}
 	text.XViewMoveTo(0.1)
 	if left, right := text.XView(); left != 0.1 || right != 1 {
@@ -652,6 +2346,65 @@ func TestYView(t *testing.T) {
 	}
 }
 
+// Run readers and writers concurrently. Public methods used to take read
+// locks recursively, which deadlocks when a writer is waiting in between; run
+// with -race to check for unsynchronized access as well.
+func TestConcurrency(t *testing.T) {
+	text := New()
+	text.SetSize(20, 10)
+	text.SetWrap(Char)
+	text.Insert("end", strings.Repeat("hello\tworld, this is a line\n", 50))
+	text.MarkSet("insert", "1.0")
+
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	readers := []func(){
+		func() { text.DLineInfo("insert") },
+		func() { text.BBox("end -3c") },
+		func() { text.Get("1.0", "end") },
+		func() { text.EditGetModified() },
+		func() { text.GetScreenLines() },
+		func() { text.YView() },
+		func() { text.MarkNext("insert") },
+		func() { text.SelectionGet() },
+	}
+	writers := []func(){
+		func() { text.Insert("insert", "x") },
+		func() { text.Delete("insert -1c", "insert") },
+		func() { text.See("insert") },
+		func() { text.MarkSet("insert", "insert +1l") },
+		func() { text.EditSetModified(false) },
+		func() { text.CursorInsert("y") },
+		func() {
+			text.Transaction(func(tx *Tx) error {
+				tx.Replace("1.0", "1.1", "z")
+				return nil
+			})
+		},
+	}
+	for _, fs := range [][]func(){readers, writers} {
+		for _, f := range fs {
+			wg.Add(1)
+			go func(f func()) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					f()
+				}
+			}(f)
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Concurrent readers and writers deadlocked")
+	}
+}
+
 func randBuffer(numLines int) *TkText {
 	buf := New()
 	lines := make([]string, numLines)
@@ -742,3 +2495,27 @@ func BenchmarkBufferInsert(b *testing.B) {
 		b.StartTimer()
 	}
 }
+
+// Average time to get the screen lines of one of many 2000-line buffers with
+// tabs, with buffers rendered concurrently.
+//
+// 2026/10/18 14:02 - 2400 ns/op
+func BenchmarkGetScreenLinesParallel(b *testing.B) {
+	bufs := make([]*TkText, 100)
+	for i := range bufs {
+		bufs[i] = randBuffer(2000)
+		bufs[i].Insert("1.0", strings.Repeat("\tindented\tline\n", 100))
+		bufs[i].SetSize(80, 50)
+		bufs[i].YViewMoveTo(0.5)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			bufs[i%len(bufs)].GetScreenLines()
+			i++
+		}
+	})
+}
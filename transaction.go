@@ -0,0 +1,122 @@
+package tktext
+
+import "container/list"
+
+// Tx is a handle for reading and editing a TkText buffer within a
+// transaction. A Tx is only valid for the duration of the function passed to
+// Transaction, and must not be used by other goroutines.
+type Tx struct {
+	t   *TkText
+	ops []interface{}
+}
+
+// Transaction calls f with a Tx that can be used to edit the buffer, holding
+// the buffer's write lock until f returns. Other goroutines never observe the
+// buffer in a partially edited state, and the buffer's methods must not be
+// called from within f. If the undo mechanism is enabled, all changes made by f
+// form a single undoable change.
+//
+// If f returns nil, the changes are committed and change handlers are called
+// once. If f returns an error, every change made by f is rolled back, leaving
+// the buffer contents, marks, and undo and redo stacks as they were, and the
+// error is returned. If f panics, its changes are rolled back in the same way
+// before the panic continues.
+func (t *TkText) Transaction(f func(tx *Tx) error) (err error) {
+	defer t.notify()
+	t.mutex.Lock()
+	defer t.unlock()
+
+	// Save state for rollback
+	marks := make(map[string]mark, len(t.marks))
+	for k, v := range t.marks {
+		marks[k] = *v
+	}
+	redo := list.New()
+	redo.PushBackList(t.redoStack)
+	changed := t.changed
+	folds := append([]fold(nil), t.folds...)
+	spacings := append([]spacingRange(nil), t.spacings...)
+	diff := t.diff
+	undoFront := t.undoStack.Front()
+	t.separate()
+
+	tx := &Tx{t: t}
+	rollback := func() {
+		for i := len(tx.ops) - 1; i >= 0; i-- {
+			switch v := tx.ops[i].(type) {
+			case insertOp:
+				t.del(t.index(v.sp), t.index(v.ep), false)
+			case deleteOp:
+				t.insert(t.index(v.sp), v.s, false)
+			}
+		}
+		for t.undoStack.Front() != undoFront {
+			t.undoStack.Remove(t.undoStack.Front())
+		}
+		t.redoStack = redo
+		t.marks = make(map[string]*mark, len(marks))
+		for k, v := range marks {
+			m := v
+			t.marks[k] = &m
+		}
+		t.changed = changed
+		t.folds = folds
+		t.spacings = spacings
+		t.diff = diff
+		t.updateElided()
+	}
+
+	// A panic in f also rolls back its changes before it is propagated
+	defer func() {
+		if r := recover(); r != nil {
+			rollback()
+			panic(r)
+		}
+	}()
+	if err = f(tx); err != nil {
+		rollback()
+	} else {
+		t.separate()
+	}
+	return err
+}
+
+// Index parses a string index and returns an equivalent valid Position in the
+// text buffer.
+func (tx *Tx) Index(index string) Position {
+	return tx.t.index(index)
+}
+
+// Get returns the text between two indices as a string. If index1 is after
+// index2, an empty string will be returned.
+func (tx *Tx) Get(index1, index2 string) string {
+	return tx.t.get(tx.t.index(index1), tx.t.index(index2))
+}
+
+// Delete deletes the text from index1 to index2. If index1 is after index2, no
+// text is deleted.
+func (tx *Tx) Delete(index1, index2 string) {
+	start, end := tx.t.index(index1), tx.t.index(index2)
+	if comparePos(start, end) < 0 {
+		s := tx.t.del(start, end, true)
+		tx.ops = append(tx.ops, deleteOp{start.String(), end.String(), s})
+	}
+}
+
+// Insert inserts the given text at the given index.
+func (tx *Tx) Insert(index, s string) {
+	if s != "" {
+		start := tx.t.index(index)
+		end := tx.t.insert(start, s, true)
+		tx.ops = append(tx.ops, insertOp{start.String(), end.String(), s})
+	}
+}
+
+// Replace replaces the text from index1 to index2 with the given text. If
+// index1 is after index2, the operation is equivalent to an insertion at
+// index1.
+func (tx *Tx) Replace(index1, index2, s string) {
+	start := tx.t.index(index1).String()
+	tx.Delete(start, index2)
+	tx.Insert(start, s)
+}
@@ -0,0 +1,98 @@
+package tktext
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes used by the wordstart and wordend index modifiers.
+const (
+	spaceClass = iota
+	punctClass
+	wordClass
+)
+
+// WordChars returns a predicate for use with SetWordChars that reports
+// whether a rune is one of the given characters or belongs to one of the
+// given Unicode range tables, like Tcl's tcl_wordchars variable.
+func WordChars(chars string, tables ...*unicode.RangeTable) func(rune) bool {
+	return func(r rune) bool {
+		for _, ch := range chars {
+			if r == ch {
+				return true
+			}
+		}
+		return unicode.In(r, tables...)
+	}
+}
+
+// Default word characters are letters, digits, and underscores.
+var defaultWordChars = WordChars("_", unicode.Letter, unicode.Digit)
+
+// Return the class of a character
+func (t *TkText) charClass(r rune) int {
+	if t.wordChars(r) {
+		return wordClass
+	} else if unicode.IsSpace(r) {
+		return spaceClass
+	}
+	return punctClass
+}
+
+// Return the start of the run of same-class characters containing s[i]. If
+// s[i-1] is a word character, the run is a word even if s[i] is not.
+func (t *TkText) wordStart(s string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	class := t.charClass(prev)
+	if class != wordClass && i < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		if t.charClass(r) != class {
+			return i
+		}
+	}
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(s[:i])
+		if t.charClass(r) != class {
+			break
+		}
+		i -= size
+	}
+	return i
+}
+
+// Return the start of the run of same-class characters containing s[i].
+func (t *TkText) runStart(s string, i int) int {
+	if i >= len(s) {
+		return t.wordStart(s, i)
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	class := t.charClass(r)
+	for i > 0 {
+		r, size := utf8.DecodeLastRuneInString(s[:i])
+		if t.charClass(r) != class {
+			break
+		}
+		i -= size
+	}
+	return i
+}
+
+// Return the end of the run of same-class characters starting at s[i].
+func (t *TkText) wordEnd(s string, i int) int {
+	if i >= len(s) {
+		return len(s)
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	class := t.charClass(r)
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if t.charClass(r) != class {
+			break
+		}
+		i += size
+	}
+	return i
+}